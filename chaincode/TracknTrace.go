@@ -20,623 +20,900 @@ under the License.
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
-	"time"
-	"strconv"
-	"encoding/json"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	
+	"reflect"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 // TnT is a high level smart contract that collaborate together business artifact based smart contracts
 type TnT struct {
+	contractapi.Contract
 }
+
 // Assembly Line Structure
-type AssemblyLine struct{	
-	AssemblyId string `json:"assemblyId"`
-	DeviceSerialNo string `json:"deviceSerialNo"`
-	DeviceType string `json:"deviceType"`
-	FilamentBatchId string `json:"filamentBatchId"`
-	LedBatchId string `json:"ledBatchId"`
-	CircuitBoardBatchId string `json:"circuitBoardBatchId"`
-	WireBatchId string `json:"wireBatchId"`
-	CasingBatchId string `json:"casingBatchId"`
-	AdaptorBatchId string `json:"adaptorBatchId"`
-	StickPodBatchId string `json:"stickPodBatchId"`
-	ManufacturingPlant string `json:"manufacturingPlant"`
-	AssemblyStatus string `json:"assemblyStatus"`
-	AssemblyCreationDate string `json:"assemblyCreationDate"`
+type AssemblyLine struct {
+	AssemblyId            string `json:"assemblyId"`
+	DeviceSerialNo        string `json:"deviceSerialNo"`
+	DeviceType            string `json:"deviceType"`
+	FilamentBatchId       string `json:"filamentBatchId"`
+	LedBatchId            string `json:"ledBatchId"`
+	CircuitBoardBatchId   string `json:"circuitBoardBatchId"`
+	WireBatchId           string `json:"wireBatchId"`
+	CasingBatchId         string `json:"casingBatchId"`
+	AdaptorBatchId        string `json:"adaptorBatchId"`
+	StickPodBatchId       string `json:"stickPodBatchId"`
+	ManufacturingPlant    string `json:"manufacturingPlant"`
+	AssemblyStatus        string `json:"assemblyStatus"`
+	AssemblyCreationDate  string `json:"assemblyCreationDate"`
 	AssemblyLastUpdatedOn string `json:"assemblyLastUpdateOn"`
-	AssemblyCreatedBy string `json:"assemblyCreatedBy"`
+	AssemblyCreatedBy     string `json:"assemblyCreatedBy"`
 	AssemblyLastUpdatedBy string `json:"assemblyLastUpdatedBy"`
-	}
+	CaseId                string `json:"caseId"`
+
+	// Components maps the component types of the assembly to their batch,
+	// validated against the bill of materials of its device type. The batch
+	// columns above mirror the component types that have one.
+	Components map[string]string `json:"components"`
+	// ComponentQuantities are the quantities taken from each batch, set by
+	// the chaincode
+	ComponentQuantities map[string]int `json:"componentQuantities"`
+}
 
 // Package Line Structure
-type PackageLine struct{	
-	CaseId string `json:"caseId"`
-	HolderAssemblyId string `json:"holderAssemblyId"`
-	ChargerAssemblyId string `json:"chargerAssemblyId"`
-	PackageStatus string `json:"packageStatus"`
-	PackagingDate string `json:"packagingDate"`
-	PackageCreationDate string `json:"packagingCreationDate"`
+type PackageLine struct {
+	CaseId               string `json:"caseId"`
+	HolderAssemblyId     string `json:"holderAssemblyId"`
+	ChargerAssemblyId    string `json:"chargerAssemblyId"`
+	PackageStatus        string `json:"packageStatus"`
+	PackagingDate        string `json:"packagingDate"`
+	PackageCreationDate  string `json:"packagingCreationDate"`
 	PackageLastUpdatedOn string `json:"packageLastUpdateOn"`
-	ShippingToAddress string `json:"shippingToAddress"`
-	PackageCreatedBy string `json:"packageCreatedBy"`
+	ShippingToAddress    string `json:"shippingToAddress"`
+	PackageCreatedBy     string `json:"packageCreatedBy"`
 	PackageLastUpdatedBy string `json:"packageLastUpdatedBy"`
+}
+
+// AssemblyCreateResult is the result of CreateAssembly
+type AssemblyCreateResult struct {
+	AssemblyId string `json:"assemblyId"`
+}
+
+// PackageCreateResult is the result of CreatePackage
+type PackageCreateResult struct {
+	CaseId string `json:"caseId"`
+}
+
+// getAssembly reads an assembly by its id, returning nil when it does not exist
+func getAssembly(stub shim.ChaincodeStubInterface, assemblyId string) (*AssemblyLine, error) {
+	assembly := new(AssemblyLine)
+	ok, err := getRecord(stub, keyAssembly, []string{assemblyId}, assembly)
+	if err != nil || !ok {
+		return nil, err
 	}
+	return assembly, nil
+}
 
-// Init initializes the smart contracts
-func (t *TnT) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-
-	// Check if table already exists - AssemblyLine
-	_, err := stub.GetTable("AssemblyLine")
-	if err == nil {
-		// Table already exists; do not recreate
-		return nil, nil
-	}
-
-	// Create application Table for Assembly Line
-	err = stub.CreateTable("AssemblyLine", []*shim.ColumnDefinition{
-		&shim.ColumnDefinition{Name: "assemblyId", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "deviceSerialNo", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "deviceType", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "filamentBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "ledBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "circuitBoardBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "wireBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "casingBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "adaptorBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "stickPodBatchId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "manufacturingPlant", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "assemblyStatus", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "assemblyCreationDate", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "assemblyLastUpdateOn", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "assemblyCreatedBy", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "assemblyLastUpdatedBy", Type: shim.ColumnDefinition_STRING, Key: false},
-	})
+// getPackage reads a package by its case id, returning nil when it does not exist
+func getPackage(stub shim.ChaincodeStubInterface, caseId string) (*PackageLine, error) {
+	_package := new(PackageLine)
+	ok, err := getRecord(stub, keyPackage, []string{caseId}, _package)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return _package, nil
+}
+
+// replaceAssembly overwrites an existing assembly with its new version, moves
+// its index entries and the components it consumes and records the change in
+// its history
+func replaceAssembly(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	ok, err := replaceRecord(stub, keyAssembly, []string{current.AssemblyId}, current)
 	if err != nil {
-		return nil, errors.New("Failed creating Assembly Line.")
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Assembly %s not found.", current.AssemblyId)
 	}
-	
 
+	err = consumeBatches(stub, previous, current)
+	if err != nil {
+		return err
+	}
 
-	// Check if table already exists: Packaging Line
-	_, err = stub.GetTable("PackageLine")
-	if err == nil {
-		// Table already exists; do not recreate
-		return nil, nil
+	err = indexAssembly(stub, previous, current)
+	if err != nil {
+		return err
 	}
 
-	// Create application Table
-	err = stub.CreateTable("PackageLine", []*shim.ColumnDefinition{
-		&shim.ColumnDefinition{Name: "caseId", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "holderAssemblyId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "chargerAssemblyId", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packageStatus", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packagingDate", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packagingCreationDate", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packageLastUpdateOn", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "shippingToAddress", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packageCreatedBy", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "packageLastUpdatedBy", Type: shim.ColumnDefinition_STRING, Key: false},
-		
-	})
+	return recordHistory(stub, objectAssembly, current.AssemblyId, current.AssemblyLastUpdatedBy, previous, current)
+}
+
+// replacePackage overwrites an existing package with its new version and
+// records the change in its history. Its assemblies are never changed, so
+// their index entries stay.
+func replacePackage(stub shim.ChaincodeStubInterface, previous *PackageLine, current *PackageLine) error {
+	ok, err := replaceRecord(stub, keyPackage, []string{current.CaseId}, current)
 	if err != nil {
-		return nil, errors.New("Failed creating Packaging Line.")
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Package %s not found.", current.CaseId)
 	}
-		
-	
-	return nil, nil
+
+	return recordHistory(stub, objectPackage, current.CaseId, current.PackageLastUpdatedBy, previous, current)
 }
-//API to create an assembly
-func (t *TnT) createAssembly(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-if len(args) != 11 {
-			return nil, fmt.Errorf("Incorrect number of arguments. Expecting 11. Got: %d.", len(args))
-		}
-		//var columns []shim.Column
-		//_assemblyId:= rand.New(rand.NewSource(99)).Int31
-
-		//Generate the AssemblyId
-		rand.Seed(time.Now().Unix())
-		
-		_assemblyId := strconv.Itoa(rand.Int())
-		_deviceSerialNo:= args[0]
-		_deviceType:=args[1]
-		_FilamentBatchId:=args[2]
-		_LedBatchId:=args[3]
-		_CircuitBoardBatchId:=args[4]
-		_WireBatchId:=args[5]
-		_CasingBatchId:=args[6]
-		_AdaptorBatchId:=args[7]
-		_StickPodBatchId:=args[8]
-		_ManufacturingPlant:=args[9]
-		_AssemblyStatus:= args[10]
-
-		_time:= time.Now().Local()
-
-		_AssemblyCreationDate := _time.Format("2006-01-02")
-		_AssemblyLastUpdateOn := _time.Format("2006-01-02")
-		_AssemblyCreatedBy := ""
-		_AssemblyLastUpdatedBy := ""
-
-		// Insert a row
-		ok, err := stub.InsertRow("AssemblyLine", shim.Row{
-			Columns: []*shim.Column{
-				&shim.Column{Value: &shim.Column_String_{String_: _assemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _deviceSerialNo}},
-				&shim.Column{Value: &shim.Column_String_{String_: _deviceType}},
-				&shim.Column{Value: &shim.Column_String_{String_: _FilamentBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _LedBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _CircuitBoardBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _WireBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _CasingBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AdaptorBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _StickPodBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _ManufacturingPlant}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyStatus}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyCreationDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyLastUpdateOn}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyCreatedBy}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyLastUpdatedBy}},
-			}})
 
-		if err != nil {
-			return nil, err 
-		}
-		if !ok && err == nil {
-			return nil, errors.New("Row already exists.")
-		}
-			
-		return nil, nil
-
-}
-
-//Update Assembly based on Id
-func (t *TnT) updateAssemblyByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-
-	if len(args) != 14 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 14.")
-	} 
-	
-		_assemblyId := args[0]
-		_deviceSerialNo:= args[1]
-		_deviceType:=args[2]
-		_FilamentBatchId:=args[3]
-		_LedBatchId:=args[4]
-		_CircuitBoardBatchId:=args[5]
-		_WireBatchId:=args[6]
-		_CasingBatchId:=args[7]
-		_AdaptorBatchId:=args[8]
-		_StickPodBatchId:=args[9]
-		_ManufacturingPlant:=args[10]
-		_AssemblyStatus:= args[11]
-		_AssemblyCreationDate := args[12]
-		_AssemblyCreatedBy :=  args[13]
-		_time:= time.Now().Local()
-		_AssemblyLastUpdateOn := _time.Format("2006-01-02")
-		_AssemblyLastUpdatedBy := ""
-
-
-		// Get the row pertaining to this Assembly Id
-		var columns []shim.Column
-		col1 := shim.Column{Value: &shim.Column_String_{String_: _assemblyId}}
-		columns = append(columns, col1)
-		// Delete the row pertaining to this assemblyId
-		err := stub.DeleteRow(
-			"Assemblyline",
-			columns,
-		)
-		if err != nil {
-			return nil, errors.New("Failed deleting row.")
-		}
+//API to create an assembly from a JSON document with the AssemblyLine fields,
+//plus an optional assemblyLine only used as part of the generated AssemblyId.
+//The fields set by the chaincode, such as the AssemblyId, are ignored.
+func (t *TnT) CreateAssemblyFromJSON(ctx contractapi.TransactionContextInterface, assembly string) (*AssemblyCreateResult, error) {
+	_assembly := new(AssemblyLine)
+	extra, err := decodeInput(assembly, createAssemblySpec, _assembly)
+	if err != nil {
+		return nil, err
+	}
 
-		// Insert a row
-		ok, error_ := stub.InsertRow("AssemblyLine", shim.Row{
-			Columns: []*shim.Column{
-				&shim.Column{Value: &shim.Column_String_{String_: _assemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _deviceSerialNo}},
-				&shim.Column{Value: &shim.Column_String_{String_: _deviceType}},
-				&shim.Column{Value: &shim.Column_String_{String_: _FilamentBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _LedBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _CircuitBoardBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _WireBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _CasingBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AdaptorBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _StickPodBatchId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _ManufacturingPlant}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyStatus}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyCreationDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyLastUpdateOn}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyCreatedBy}},
-				&shim.Column{Value: &shim.Column_String_{String_: _AssemblyLastUpdatedBy}},
-			}})
-
-		if error_ != nil {
-			return nil, error_ 
-		}
-		if !ok && error_ == nil {
-			return nil, errors.New("Row already exists in Assemblyline.")
-		}
-		
-	return nil, nil
+	return createAssembly(ctx, _assembly, extra["assemblyLine"])
+}
+
+//API to create an assembly. The assembly line is optional and only used as
+//part of the generated AssemblyId.
+//
+//Deprecated: use CreateAssemblyFromJSON, which names every field.
+func (t *TnT) CreateAssembly(ctx contractapi.TransactionContextInterface, deviceSerialNo string, deviceType string, filamentBatchId string, ledBatchId string, circuitBoardBatchId string, wireBatchId string, casingBatchId string, adaptorBatchId string, stickPodBatchId string, manufacturingPlant string, assemblyStatus string, assemblyLine string) (*AssemblyCreateResult, error) {
+	_assembly := &AssemblyLine{
+		DeviceSerialNo:      deviceSerialNo,
+		DeviceType:          deviceType,
+		FilamentBatchId:     filamentBatchId,
+		LedBatchId:          ledBatchId,
+		CircuitBoardBatchId: circuitBoardBatchId,
+		WireBatchId:         wireBatchId,
+		CasingBatchId:       casingBatchId,
+		AdaptorBatchId:      adaptorBatchId,
+		StickPodBatchId:     stickPodBatchId,
+		ManufacturingPlant:  manufacturingPlant,
+		AssemblyStatus:      assemblyStatus,
+	}
 
+	return createAssembly(ctx, _assembly, assemblyLine)
 }
 
-//API to create a package
-func (t *TnT) createPackage(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-		if len(args) != 5 {
-			return nil, fmt.Errorf("Incorrect number of arguments. Expecting 5. Got: %d.", len(args))
-		}
-	
-		rand.Seed(time.Now().Unix())
-		
-		_caseId := strconv.Itoa(rand.Int())
-		_holderAssemblyId:= args[0]
-		_chargerAssemblyId:=args[1]
-		_packageStatus:=args[2]
-		_packagingDate:=args[3]
-		_shippingtoAddress:=args[4]
-		_time:= time.Now().Local()
-
-		_packageCreationDate := _time.Format("2006-01-02")
-		_packageLastUpdateOn := _time.Format("2006-01-02")
-		_packageCreatedBy := ""
-		_packageLastUpdatedBy := ""
-
-		// Insert a row
-		ok, err := stub.InsertRow("PackageLine", shim.Row{
-			Columns: []*shim.Column{
-				&shim.Column{Value: &shim.Column_String_{String_: _caseId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _holderAssemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _chargerAssemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageStatus}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packagingDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageCreationDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageLastUpdateOn}},
-				&shim.Column{Value: &shim.Column_String_{String_: _shippingtoAddress}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageCreatedBy}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageLastUpdatedBy}},	
-		}})
-		if err != nil {
-			return nil, err 
+// createAssembly creates an assembly from the fields given by the caller
+func createAssembly(ctx contractapi.TransactionContextInterface, _assembly *AssemblyLine, assemblyLine string) (*AssemblyCreateResult, error) {
+	stub := ctx.GetStub()
+
+	// Operators only create assemblies of their own plant
+	err := checkPlantWrite(ctx, _assembly.ManufacturingPlant)
+	if err != nil {
+		return nil, err
+	}
+
+	// The assembly must start in an initial status of its lifecycle
+	err = checkTransition(stub, objectAssembly, "", _assembly.AssemblyStatus)
+	if err != nil {
+		return nil, err
+	}
+	err = checkUnpackedStatus(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	// The serial number must be unique
+	err = checkSerialNo(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	// The device type must be in the catalog and its components must match
+	// its bill of materials
+	err = checkDeviceType(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareComponents(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	//Generate the AssemblyId
+	_assemblyId, err := nextID(stub, assemblySeqKey, assemblyIDPrefix, _assembly.ManufacturingPlant, assemblyLine)
+	if err != nil {
+		return nil, err
+	}
+
+	// The creator comes from the caller's certificate, never from the arguments
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	_assembly.AssemblyId = _assemblyId
+	_assembly.AssemblyCreationDate = _time.Format("2006-01-02")
+	_assembly.AssemblyLastUpdatedOn = _time.Format("2006-01-02")
+	_assembly.AssemblyCreatedBy = _caller
+	_assembly.AssemblyLastUpdatedBy = _caller
+	_assembly.CaseId = ""
+
+	ok, err := insertRecord(stub, keyAssembly, []string{_assemblyId}, _assembly)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Assembly %s already exists.", _assemblyId)
+	}
+
+	// Take the components from the batches the assembly is built with
+	err = consumeBatches(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the first version of the assembly
+	err = recordHistory(stub, objectAssembly, _assemblyId, _assembly.AssemblyLastUpdatedBy, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the assembly under its status, plant, serial number and batches
+	err = indexAssembly(stub, nil, _assembly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AssemblyCreateResult{AssemblyId: _assemblyId}, nil
+}
+
+//API to update an assembly from a JSON document with the AssemblyLine fields.
+//The fields set by the chaincode, such as the creation date, are ignored.
+func (t *TnT) UpdateAssemblyFromJSON(ctx contractapi.TransactionContextInterface, assembly string) error {
+	_assembly := new(AssemblyLine)
+	_, err := decodeInput(assembly, updateAssemblySpec, _assembly)
+	if err != nil {
+		return err
+	}
+
+	return updateAssembly(ctx, _assembly)
+}
+
+//Update Assembly based on Id. The creation date is kept, whatever
+//assemblyCreationDate says.
+//
+//Deprecated: use UpdateAssemblyFromJSON, which names every field.
+func (t *TnT) UpdateAssemblyByID(ctx contractapi.TransactionContextInterface, assemblyId string, deviceSerialNo string, deviceType string, filamentBatchId string, ledBatchId string, circuitBoardBatchId string, wireBatchId string, casingBatchId string, adaptorBatchId string, stickPodBatchId string, manufacturingPlant string, assemblyStatus string, assemblyCreationDate string) error {
+	_assembly := &AssemblyLine{
+		AssemblyId:          assemblyId,
+		DeviceSerialNo:      deviceSerialNo,
+		DeviceType:          deviceType,
+		FilamentBatchId:     filamentBatchId,
+		LedBatchId:          ledBatchId,
+		CircuitBoardBatchId: circuitBoardBatchId,
+		WireBatchId:         wireBatchId,
+		CasingBatchId:       casingBatchId,
+		AdaptorBatchId:      adaptorBatchId,
+		StickPodBatchId:     stickPodBatchId,
+		ManufacturingPlant:  manufacturingPlant,
+		AssemblyStatus:      assemblyStatus,
+	}
+
+	return updateAssembly(ctx, _assembly)
+}
+
+// updateAssembly replaces an existing assembly with the fields given by the
+// caller
+func updateAssembly(ctx contractapi.TransactionContextInterface, _assembly *AssemblyLine) error {
+	stub := ctx.GetStub()
+
+	// Updates never create assemblies
+	_previous, err := getAssembly(stub, _assembly.AssemblyId)
+	if err != nil {
+		return err
+	}
+	if _previous == nil {
+		return fmt.Errorf("Assembly %s not found.", _assembly.AssemblyId)
+	}
+
+	// Operators only update assemblies of their own plant, and keep them there
+	err = checkPlantWrite(ctx, _previous.ManufacturingPlant)
+	if err != nil {
+		return err
+	}
+	err = checkPlantWrite(ctx, _assembly.ManufacturingPlant)
+	if err != nil {
+		return err
+	}
+
+	// Only the transitions of the assembly lifecycle are allowed
+	err = checkTransition(stub, objectAssembly, _previous.AssemblyStatus, _assembly.AssemblyStatus)
+	if err != nil {
+		return err
+	}
+	err = checkUnpackedStatus(stub, _previous, _assembly)
+	if err != nil {
+		return err
+	}
+
+	// Without components the assembly keeps its own, but for the batch columns
+	// the caller changed. With them, the columns left empty keep their batch.
+	if _assembly.Components == nil {
+		_assembly.Components = map[string]string{}
+		for componentType, batchId := range _previous.Components {
+			_assembly.Components[componentType] = batchId
 		}
-		if !ok && err == nil {
-			return nil, errors.New("Row already exists.")
+	} else {
+		for _, c := range componentColumns {
+			if *c.Column(_assembly) == "" {
+				*c.Column(_assembly) = *c.Column(_previous)
+			}
 		}
+	}
+	err = checkSerialNo(stub, _previous, _assembly)
+	if err != nil {
+		return err
+	}
+	err = checkDeviceType(stub, _previous, _assembly)
+	if err != nil {
+		return err
+	}
+	err = prepareComponents(stub, _previous, _assembly)
+	if err != nil {
+		return err
+	}
+
+	// The updater comes from the caller's certificate
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	// The creator, the creation date and the case are never changed by an
+	// update
+	_assembly.AssemblyCreatedBy = _previous.AssemblyCreatedBy
+	_assembly.AssemblyCreationDate = _previous.AssemblyCreationDate
+	_assembly.CaseId = _previous.CaseId
+	_assembly.AssemblyLastUpdatedOn = _time.Format("2006-01-02")
+	_assembly.AssemblyLastUpdatedBy = _caller
+
+	// Overwrite the row in place, move its index entries and record the change
+	return replaceAssembly(stub, _previous, _assembly)
+}
+
+//API to change some fields of an assembly. The patch is a JSON merge patch of
+//the changed AssemblyLine fields, null clearing a field. The fields set by the
+//chaincode cannot be changed.
+func (t *TnT) PatchAssembly(ctx contractapi.TransactionContextInterface, assemblyId string, patch string) error {
+	stub := ctx.GetStub()
+
+	_previous, err := getAssembly(stub, assemblyId)
+	if err != nil {
+		return err
+	}
+	if _previous == nil {
+		return fmt.Errorf("Assembly %s not found.", assemblyId)
+	}
+
+	_assembly := *_previous
+	err = applyPatch(patch, assemblyPatchSpec, &_assembly)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(_assembly, *_previous) {
+		return nil
+	}
+
+	// Operators only move assemblies within their own plant
+	err = checkPlantWrite(ctx, _previous.ManufacturingPlant)
+	if err != nil {
+		return err
+	}
+	err = checkPlantWrite(ctx, _assembly.ManufacturingPlant)
+	if err != nil {
+		return err
+	}
+
+	err = checkTransition(stub, objectAssembly, _previous.AssemblyStatus, _assembly.AssemblyStatus)
+	if err != nil {
+		return err
+	}
+	err = checkUnpackedStatus(stub, _previous, &_assembly)
+	if err != nil {
+		return err
+	}
+
+	err = checkSerialNo(stub, _previous, &_assembly)
+	if err != nil {
+		return err
+	}
+	err = checkDeviceType(stub, _previous, &_assembly)
+	if err != nil {
+		return err
+	}
+	err = prepareComponents(stub, _previous, &_assembly)
+	if err != nil {
+		return err
+	}
+
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	_assembly.AssemblyLastUpdatedOn = _time.Format("2006-01-02")
+	_assembly.AssemblyLastUpdatedBy = _caller
+
+	return replaceAssembly(stub, _previous, &_assembly)
+}
+
+//API to create a package from a JSON document with the PackageLine fields,
+//plus an optional packingLine only used as part of the generated CaseId.
+//The fields set by the chaincode, such as the CaseId, are ignored.
+func (t *TnT) CreatePackageFromJSON(ctx contractapi.TransactionContextInterface, _package string) (*PackageCreateResult, error) {
+	_input := new(PackageLine)
+	extra, err := decodeInput(_package, createPackageSpec, _input)
+	if err != nil {
+		return nil, err
+	}
+
+	return createPackage(ctx, _input, extra["packingLine"])
+}
+
+//API to create a package. The packing line is optional and only used as part
+//of the generated CaseId.
+//
+//Deprecated: use CreatePackageFromJSON, which names every field.
+func (t *TnT) CreatePackage(ctx contractapi.TransactionContextInterface, holderAssemblyId string, chargerAssemblyId string, packageStatus string, packagingDate string, shippingToAddress string, packingLine string) (*PackageCreateResult, error) {
+	_package := &PackageLine{
+		HolderAssemblyId:  holderAssemblyId,
+		ChargerAssemblyId: chargerAssemblyId,
+		PackageStatus:     packageStatus,
+		PackagingDate:     packagingDate,
+		ShippingToAddress: shippingToAddress,
+	}
+
+	return createPackage(ctx, _package, packingLine)
+}
+
+// createPackage creates a package from the fields given by the caller and
+// marks both of its assemblies as packaged
+func createPackage(ctx contractapi.TransactionContextInterface, _package *PackageLine, packingLine string) (*PackageCreateResult, error) {
+	stub := ctx.GetStub()
+
+	// The package must start in an initial status of its lifecycle
+	err := checkTransition(stub, objectPackage, "", _package.PackageStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both assemblies must be ready to be packed
+	if _package.HolderAssemblyId == _package.ChargerAssemblyId {
+		return nil, errors.New("Holder and charger assembly must be different.")
+	}
+	_holderAssembly, err := getPackableAssembly(ctx, _package.HolderAssemblyId, PackagingRoleHolder)
+	if err != nil {
+		return nil, err
+	}
+	_chargerAssembly, err := getPackableAssembly(ctx, _package.ChargerAssemblyId, PackagingRoleCharger)
+	if err != nil {
+		return nil, err
+	}
+
+	//Generate the CaseId
+	_caseId, err := nextID(stub, caseSeqKey, caseIDPrefix, packingLine)
+	if err != nil {
+		return nil, err
+	}
+
+	// The creator comes from the caller's certificate, never from the arguments
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	_package.CaseId = _caseId
+	_package.PackageCreationDate = _time.Format("2006-01-02")
+	_package.PackageLastUpdatedOn = _time.Format("2006-01-02")
+	_package.PackageCreatedBy = _caller
+	_package.PackageLastUpdatedBy = _caller
+
+	ok, err := insertRecord(stub, keyPackage, []string{_caseId}, _package)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Package %s already exists.", _caseId)
+	}
+
+	// Record the first version of the package
+	err = recordHistory(stub, objectPackage, _caseId, _package.PackageLastUpdatedBy, nil, _package)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the case under both of its assemblies
+	err = indexPackageAssemblies(stub, _package)
+	if err != nil {
+		return nil, err
+	}
+
+	//Update the holder and charger assembly id status as "Packaged"
+	err = markAssemblyPackaged(ctx, _holderAssembly, _caseId)
+	if err != nil {
+		return nil, err
+	}
+	err = markAssemblyPackaged(ctx, _chargerAssembly, _caseId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackageCreateResult{CaseId: _caseId}, nil
+}
+
+//API to update a package from a JSON document with the PackageLine fields.
+//The fields set by the chaincode, such as the creation date, are ignored.
+func (t *TnT) UpdatePackageFromJSON(ctx contractapi.TransactionContextInterface, _package string) error {
+	_input := new(PackageLine)
+	_, err := decodeInput(_package, updatePackageSpec, _input)
+	if err != nil {
+		return err
+	}
+
+	return updatePackage(ctx, _input)
+}
+
+//Update Package based on CaseId. The creation date is kept, whatever
+//packagingCreationDate says.
+//
+//Deprecated: use UpdatePackageFromJSON, which names every field.
+func (t *TnT) UpdatePackageByCaseID(ctx contractapi.TransactionContextInterface, caseId string, holderAssemblyId string, chargerAssemblyId string, packageStatus string, packagingDate string, shippingToAddress string, packagingCreationDate string) error {
+	_package := &PackageLine{
+		CaseId:            caseId,
+		HolderAssemblyId:  holderAssemblyId,
+		ChargerAssemblyId: chargerAssemblyId,
+		PackageStatus:     packageStatus,
+		PackagingDate:     packagingDate,
+		ShippingToAddress: shippingToAddress,
+	}
+
+	return updatePackage(ctx, _package)
+}
+
+// updatePackage replaces an existing package with the fields given by the
+// caller, which must keep its assemblies
+func updatePackage(ctx contractapi.TransactionContextInterface, _package *PackageLine) error {
+	stub := ctx.GetStub()
+
+	// Updates never create packages
+	_previous, err := getPackage(stub, _package.CaseId)
+	if err != nil {
+		return err
+	}
+	if _previous == nil {
+		return fmt.Errorf("Package %s not found.", _package.CaseId)
+	}
+
+	// The assemblies of a case are fixed when it is packed
+	_fixed := []FieldError{}
+	if _package.HolderAssemblyId != _previous.HolderAssemblyId {
+		_fixed = append(_fixed, FieldError{Field: "holderAssemblyId", Problem: "cannot be changed"})
+	}
+	if _package.ChargerAssemblyId != _previous.ChargerAssemblyId {
+		_fixed = append(_fixed, FieldError{Field: "chargerAssemblyId", Problem: "cannot be changed"})
+	}
+	if len(_fixed) > 0 {
+		return &InputError{Object: "package", Fields: _fixed}
+	}
+
+	// Only the transitions of the package lifecycle are allowed
+	err = checkTransition(stub, objectPackage, _previous.PackageStatus, _package.PackageStatus)
+	if err != nil {
+		return err
+	}
+
+	// The updater comes from the caller's certificate
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	// The creator and the creation date are never changed by an update
+	_package.PackageCreatedBy = _previous.PackageCreatedBy
+	_package.PackageCreationDate = _previous.PackageCreationDate
+	_package.PackageLastUpdatedOn = _time.Format("2006-01-02")
+	_package.PackageLastUpdatedBy = _caller
+
+	// Overwrite the row in place and record the change
+	return replacePackage(stub, _previous, _package)
+}
+
+//API to change some fields of a package. The patch is a JSON merge patch of
+//the changed PackageLine fields, null clearing a field. The fields set by the
+//chaincode and the assemblies of the case cannot be changed.
+func (t *TnT) PatchPackage(ctx contractapi.TransactionContextInterface, caseId string, patch string) error {
+	stub := ctx.GetStub()
+
+	_previous, err := getPackage(stub, caseId)
+	if err != nil {
+		return err
+	}
+	if _previous == nil {
+		return fmt.Errorf("Package %s not found.", caseId)
+	}
+
+	_package := *_previous
+	err = applyPatch(patch, packagePatchSpec, &_package)
+	if err != nil {
+		return err
+	}
+	if _package == *_previous {
+		return nil
+	}
+
+	err = checkTransition(stub, objectPackage, _previous.PackageStatus, _package.PackageStatus)
+	if err != nil {
+		return err
+	}
+
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	_package.PackageLastUpdatedOn = _time.Format("2006-01-02")
+	_package.PackageLastUpdatedBy = _caller
+
+	return replacePackage(stub, _previous, &_package)
+}
+
+// decodeAssembly decodes a stored assembly
+func decodeAssembly(value []byte) (*AssemblyLine, error) {
+	assembly := new(AssemblyLine)
+	err := json.Unmarshal(value, assembly)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupt assembly: %s", err)
+	}
+	return assembly, nil
+}
+
+// decodePackage decodes a stored package
+func decodePackage(value []byte) (*PackageLine, error) {
+	_package := new(PackageLine)
+	err := json.Unmarshal(value, _package)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupt package: %s", err)
+	}
+	return _package, nil
+}
 
-		//Update the holder and charger assembly id status as "Packaged" - implement later
-		return nil, nil
-
-}
-
-//Update Package based on CaseId
-func (t *TnT) updatePackageByCaseID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 8 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 14.")
-		} 
-	
-		_caseId := args[0]
-		_holderAssemblyId:= args[1]
-		_chargerAssemblyId:=args[2]
-		_packageStatus:=args[3]
-		_packagingDate:=args[4]
-		_shippingtoAddress:=args[5]
-		_packageCreationDate := args[6]
-		_packageCreatedBy :=  args[7]
-		_time:= time.Now().Local()
-
-		_packageLastUpdateOn := _time.Format("2006-01-02")
-		_packageLastUpdatedBy := ""
-
-		// Get the row pertaining to this Assembly Id
-		var columns []shim.Column
-		col1 := shim.Column{Value: &shim.Column_String_{String_: _caseId}}
-		columns = append(columns, col1)
-		// Delete the row pertaining to this assemblyId
-		err := stub.DeleteRow(
-			"PackageLine",
-			columns,
-		)
+//get all AssemblyLines
+func (t *TnT) GetAllAssembly(ctx contractapi.TransactionContextInterface) ([]*AssemblyLine, error) {
+	res2E := []*AssemblyLine{}
+	err := scanRecords(ctx.GetStub(), keyAssembly, []string{}, func(keys []string, value []byte) error {
+		newApp, err := decodeAssembly(value)
 		if err != nil {
-			return nil, errors.New("Failed deleting row.")
+			return err
 		}
+		res2E = append(res2E, newApp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filterReadable(ctx, res2E), nil
+}
 
-		// Insert a row
-		ok, _error := stub.InsertRow("PackageLine", shim.Row{
-			Columns: []*shim.Column{
-			&shim.Column{Value: &shim.Column_String_{String_: _caseId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _holderAssemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _chargerAssemblyId}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageStatus}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packagingDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageCreationDate}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageLastUpdateOn}},
-				&shim.Column{Value: &shim.Column_String_{String_: _shippingtoAddress}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageCreatedBy}},
-				&shim.Column{Value: &shim.Column_String_{String_: _packageLastUpdatedBy}},
-			}})
-		if _error != nil {
-			return nil, _error 
+//get all AssemblyLines a page at a time. A page size of 0 selects the default.
+func (t *TnT) GetAllAssemblyPage(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*AssemblyPage, error) {
+	pageSize, err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the assemblies of plants the caller may not read
+	scope := callerPlantScope(ctx)
+	res2E := []*AssemblyLine{}
+	next, err := scanPage(ctx.GetStub(), keyAssembly, []string{}, pageSize, bookmark, func(keys []string, value []byte) error {
+		newApp, err := decodeAssembly(value)
+		if err != nil {
+			return err
 		}
-		if !ok && _error == nil {
-			return nil, errors.New("Row already exists.")
+		if scope.canRead(newApp.ManufacturingPlant) {
+			res2E = append(res2E, newApp)
 		}
-		
-	return nil, nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	return &AssemblyPage{Items: res2E, NextBookmark: next, Count: len(res2E)}, nil
 }
 
-//get all AssemblyLines
-func (t *TnT) getAllAssembly(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {	
-var columns []shim.Column
-
-	rows, err := stub.GetRows("AssemblyLine", columns)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve row")
-	}
- 
-   
-		
-	res2E:= []*AssemblyLine{}	
-	
-	for row := range rows {		
-		newApp:= new(AssemblyLine)
-		newApp.AssemblyId = row.Columns[0].GetString_()
-		newApp.DeviceSerialNo = row.Columns[1].GetString_()
-		newApp.DeviceType = row.Columns[2].GetString_()
-		newApp.FilamentBatchId = row.Columns[3].GetString_()
-		newApp.LedBatchId = row.Columns[4].GetString_()
-		newApp.CircuitBoardBatchId = row.Columns[5].GetString_()
-		newApp.WireBatchId = row.Columns[6].GetString_()
-		newApp.CasingBatchId = row.Columns[7].GetString_()
-		newApp.AdaptorBatchId = row.Columns[8].GetString_()
-		newApp.StickPodBatchId  = row.Columns[9].GetString_()
-		newApp.ManufacturingPlant  = row.Columns[10].GetString_()
-		newApp.AssemblyStatus  = row.Columns[11].GetString_()
-		newApp.AssemblyCreationDate  = row.Columns[12].GetString_()
-		newApp.AssemblyLastUpdatedOn  = row.Columns[13].GetString_()
-		newApp.AssemblyCreatedBy  = row.Columns[14].GetString_()
-		newApp.AssemblyLastUpdatedBy  = row.Columns[15].GetString_()
-		if len(newApp.AssemblyId) > 0{
-		res2E=append(res2E,newApp)		
-		}				
-	}
-	
-    mapB, _ := json.Marshal(res2E)
-    fmt.Println(string(mapB))
-	
-	return mapB, nil
+//get the Assembly against ID
+func (t *TnT) GetAssemblyByID(ctx contractapi.TransactionContextInterface, assemblyId string) ([]*AssemblyLine, error) {
+	newApp, err := getAssembly(ctx.GetStub(), assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if newApp == nil {
+		return nil, fmt.Errorf("Assembly %s not found.", assemblyId)
+	}
+	err = checkPlantRead(ctx, newApp.ManufacturingPlant)
+	if err != nil {
+		return nil, err
+	}
 
+	// Keep returning a list, as clients expect
+	return []*AssemblyLine{newApp}, nil
 }
 
-//get the Assembly against ID
-func (t *TnT) getAssemblyByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting AssemblyID to query")
-	}
-
-	_assemblyId := args[0]
-	
-	var columns []shim.Column
-	
-	rows, err := stub.GetRows("AssemblyLine", columns)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve row")
-	}
-		
-	res2E:= []*AssemblyLine{}	
-	
-	for row := range rows {		
-		newApp:= new(AssemblyLine)
-		newApp.AssemblyId = row.Columns[0].GetString_()
-		newApp.DeviceSerialNo = row.Columns[1].GetString_()
-		newApp.DeviceType = row.Columns[2].GetString_()
-		newApp.FilamentBatchId = row.Columns[3].GetString_()
-		newApp.LedBatchId = row.Columns[4].GetString_()
-		newApp.CircuitBoardBatchId = row.Columns[5].GetString_()
-		newApp.WireBatchId = row.Columns[6].GetString_()
-		newApp.CasingBatchId = row.Columns[7].GetString_()
-		newApp.AdaptorBatchId = row.Columns[8].GetString_()
-		newApp.StickPodBatchId  = row.Columns[9].GetString_()
-		newApp.ManufacturingPlant  = row.Columns[10].GetString_()
-		newApp.AssemblyStatus  = row.Columns[11].GetString_()
-		newApp.AssemblyCreationDate  = row.Columns[12].GetString_()
-		newApp.AssemblyLastUpdatedOn  = row.Columns[13].GetString_()
-		newApp.AssemblyCreatedBy  = row.Columns[14].GetString_()
-		newApp.AssemblyLastUpdatedBy  = row.Columns[15].GetString_()
-		// Get the row pertaining to this assemblyID
-		if newApp.AssemblyId == _assemblyId{
-			res2E=append(res2E,newApp)		
-		}			
-	}
-	 mapB, _ := json.Marshal(res2E)
-    fmt.Println(string(mapB))
-	
-	return mapB, nil
+//get an Assembly by the device serial number printed on the product
+func (t *TnT) GetAssemblyBySerialNo(ctx contractapi.TransactionContextInterface, deviceSerialNo string) (*AssemblyLine, error) {
+	stub := ctx.GetStub()
 
+	assemblyId, err := getAssemblyIdBySerialNo(stub, deviceSerialNo)
+	if err != nil {
+		return nil, err
+	}
+	if assemblyId == "" {
+		return nil, fmt.Errorf("No assembly with device serial number %s.", deviceSerialNo)
+	}
+	_assembly, err := getAssembly(stub, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if _assembly == nil {
+		return nil, fmt.Errorf("Assembly %s not found.", assemblyId)
+	}
+	err = checkPlantRead(ctx, _assembly.ManufacturingPlant)
+	if err != nil {
+		return nil, err
+	}
+	return _assembly, nil
 }
 
 //get all Assembly by status
-func (t *TnT) getAllAssemblyByStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {	
-
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting Assmebly Status to query")
-	}
-
-	_AssemblyStatus := args[0]
-	
-	// Get the row pertaining to this status
-	var columns []shim.Column
-	
-	rows, err := stub.GetRows("AssemblyLine", columns)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve row")
-	}
-		
-	res2E:= []*AssemblyLine{}	
-	
-	for row := range rows {		
-		newApp:= new(AssemblyLine)
-		newApp.AssemblyId = row.Columns[0].GetString_()
-		newApp.DeviceSerialNo = row.Columns[1].GetString_()
-		newApp.DeviceType = row.Columns[2].GetString_()
-		newApp.FilamentBatchId = row.Columns[3].GetString_()
-		newApp.LedBatchId = row.Columns[4].GetString_()
-		newApp.CircuitBoardBatchId = row.Columns[5].GetString_()
-		newApp.WireBatchId = row.Columns[6].GetString_()
-		newApp.CasingBatchId = row.Columns[7].GetString_()
-		newApp.AdaptorBatchId = row.Columns[8].GetString_()
-		newApp.StickPodBatchId  = row.Columns[9].GetString_()
-		newApp.ManufacturingPlant  = row.Columns[10].GetString_()
-		newApp.AssemblyStatus  = row.Columns[11].GetString_()
-		newApp.AssemblyCreationDate  = row.Columns[12].GetString_()
-		newApp.AssemblyLastUpdatedOn  = row.Columns[13].GetString_()
-		newApp.AssemblyCreatedBy  = row.Columns[14].GetString_()
-		newApp.AssemblyLastUpdatedBy  = row.Columns[15].GetString_()
-		if newApp.AssemblyStatus == _AssemblyStatus{
-		res2E=append(res2E,newApp)		
-		}			
-	}
-	
-    mapB, _ := json.Marshal(res2E)
-    fmt.Println(string(mapB))
-	
-	return mapB, nil
+func (t *TnT) GetAllAssemblyByStatus(ctx contractapi.TransactionContextInterface, assemblyStatus string) ([]*AssemblyLine, error) {
+	res2E, err := getAssembliesByIndex(ctx.GetStub(), assemblyByStatus, assemblyStatus)
+	if err != nil {
+		return nil, err
+	}
 
+	return filterReadable(ctx, res2E), nil
+}
+
+//get all Assembly by status a page at a time, in assemblyId order
+func (t *TnT) GetAllAssemblyByStatusPage(ctx contractapi.TransactionContextInterface, assemblyStatus string, pageSize int32, bookmark string) (*AssemblyPage, error) {
+	pageSize, err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	stub := ctx.GetStub()
+	res2E := []*AssemblyLine{}
+	next, err := scanPage(stub, assemblyByStatus.Name, []string{assemblyStatus}, pageSize, bookmark, func(keys []string, value []byte) error {
+		newApp, err := getAssembly(stub, keys[1])
+		if err != nil {
+			return err
+		}
+		if newApp != nil {
+			res2E = append(res2E, newApp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res2E = filterReadable(ctx, res2E)
+
+	return &AssemblyPage{Items: res2E, NextBookmark: next, Count: len(res2E)}, nil
+}
+
+//get all Assembly by manufacturing plant
+func (t *TnT) GetAllAssemblyByPlant(ctx contractapi.TransactionContextInterface, manufacturingPlant string) ([]*AssemblyLine, error) {
+	err := checkPlantRead(ctx, manufacturingPlant)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAssembliesByIndex(ctx.GetStub(), assemblyByPlant, manufacturingPlant)
 }
 
 //get all Packages
-func (t *TnT) getAllPackage(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {	
-	var columns []shim.Column
-
-	rows, err := stub.GetRows("PackageLine", columns)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve row")
-	}
-	
-	res2E:= []*PackageLine{}	
-	
-	for row := range rows {		
-		newApp:= new(PackageLine)
-		newApp.CaseId = row.Columns[0].GetString_()
-		newApp.HolderAssemblyId = row.Columns[1].GetString_()
-		newApp.ChargerAssemblyId = row.Columns[2].GetString_()
-		newApp.PackageStatus = row.Columns[3].GetString_()
-		newApp.PackagingDate = row.Columns[4].GetString_()
-		newApp.PackageCreationDate = row.Columns[5].GetString_()
-		newApp.PackageLastUpdatedOn = row.Columns[6].GetString_()
-		newApp.ShippingToAddress = row.Columns[7].GetString_()
-		newApp.PackageCreatedBy = row.Columns[8].GetString_()
-		newApp.PackageLastUpdatedBy  = row.Columns[9].GetString_()
-		if len(newApp.CaseId) > 0{
-			res2E=append(res2E,newApp)		
-		}				
-	}
-	
-    mapB, _ := json.Marshal(res2E)
-    fmt.Println(string(mapB))
-	
-	return mapB, nil
+func (t *TnT) GetAllPackage(ctx contractapi.TransactionContextInterface) ([]*PackageLine, error) {
+	res2E := []*PackageLine{}
+	err := scanRecords(ctx.GetStub(), keyPackage, []string{}, func(keys []string, value []byte) error {
+		newApp, err := decodePackage(value)
+		if err != nil {
+			return err
+		}
+		res2E = append(res2E, newApp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	return res2E, nil
+}
+
+//get all Packages a page at a time. A page size of 0 selects the default.
+func (t *TnT) GetAllPackagePage(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PackagePage, error) {
+	pageSize, err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	res2E := []*PackageLine{}
+	next, err := scanPage(ctx.GetStub(), keyPackage, []string{}, pageSize, bookmark, func(keys []string, value []byte) error {
+		newApp, err := decodePackage(value)
+		if err != nil {
+			return err
+		}
+		res2E = append(res2E, newApp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackagePage{Items: res2E, NextBookmark: next, Count: len(res2E)}, nil
 }
 
 //get the Package against ID
-func (t *TnT) getPackageByID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting Case Id to query")
-	}
-
-	_caseId := args[0]
-	var columns []shim.Column
-	rows, err := stub.GetRows("PackageLine", columns)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to retrieve row")
-	}
-		res2E:= []*PackageLine{}	
-	
-	for row := range rows {		
-		newApp:= new(PackageLine)
-		newApp.CaseId = row.Columns[0].GetString_()
-		newApp.HolderAssemblyId = row.Columns[1].GetString_()
-		newApp.ChargerAssemblyId = row.Columns[2].GetString_()
-		newApp.PackageStatus = row.Columns[3].GetString_()
-		newApp.PackagingDate = row.Columns[4].GetString_()
-		newApp.PackageCreationDate = row.Columns[5].GetString_()
-		newApp.PackageLastUpdatedOn = row.Columns[6].GetString_()
-		newApp.ShippingToAddress = row.Columns[7].GetString_()
-		newApp.PackageCreatedBy = row.Columns[8].GetString_()
-		newApp.PackageLastUpdatedBy  = row.Columns[9].GetString_()
-		// Get the row pertaining to this caseId
-		if newApp.CaseId == _caseId	{
-		res2E=append(res2E,newApp)		
-		}				
-	}
-
-	//return []byte (row), nil
-	 mapB, _ := json.Marshal(res2E)
-    fmt.Println(string(mapB))
-	
-	return mapB, nil
-
-}
-
-// Invoke callback representing the invocation of a chaincode
-func (t *TnT) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Printf("Invoke called, determining function")
-	
-	// Handle different functions
-	if function == "init" {
-		fmt.Printf("Function is init")
-		return t.Init(stub, function, args)
-	} else if function == "createAssembly" {
-		fmt.Printf("Function is createAssembly")
-		return t.createAssembly(stub, args)
-	} else if function == "updateAssemblyByID" {
-		fmt.Printf("Function is updateAssemblyByID")
-		return t.updateAssemblyByID(stub, args)
-	}else if function == "createPackage" {
-		fmt.Printf("Function is createPackage")
-		return t.createPackage(stub, args)
-	} else if function == "updatePackageByCaseID" {
-		fmt.Printf("Function is updatePackageByCaseID")
-		return t.updatePackageByCaseID(stub, args)
-	}  
-
-	return nil, errors.New("Received unknown function invocation")
-}
-
-
-// query queries the chaincode
-func (t *TnT) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Printf("Query called, determining function")
-
-	if function == "getAllAssemblyByStatus" { 
-		t := TnT{}
-		return t.getAllAssemblyByStatus(stub, args)
-	} else 
-	if function == "getAssemblyByID" { 
-		t := TnT{}
-		return t.getAssemblyByID(stub, args)
-	}else if function == "getAllAssembly" { 
-		t := TnT{}
-		return t.getAllAssembly(stub, args)
-	}else if function == "getAllPackage" { 
-		t := TnT{}
-		return t.getAllPackage(stub, args)
-	}else if function == "getPackageByID" { 
-		t := TnT{}
-		return t.getPackageByID(stub, args)
-	}
-	
-	return nil, errors.New("Received unknown function query")
-}
-
-	func main() {
-	err := shim.Start(new(TnT))
+func (t *TnT) GetPackageByID(ctx contractapi.TransactionContextInterface, caseId string) ([]*PackageLine, error) {
+	newApp, err := getPackage(ctx.GetStub(), caseId)
+	if err != nil {
+		return nil, err
+	}
+	if newApp == nil {
+		return nil, fmt.Errorf("Package %s not found.", caseId)
+	}
+
+	// Keep returning a list, as clients expect
+	return []*PackageLine{newApp}, nil
+}
+
+func main() {
+	tnt := new(TnT)
+	// Every transaction but the queries is restricted to the roles of the AccessPolicy
+	tnt.BeforeTransaction = beforeTransaction
+
+	chaincode, err := contractapi.NewChaincode(tnt)
+	if err != nil {
+		fmt.Printf("Error creating TnT chaincode: %s", err)
+		return
+	}
+	err = chaincode.Start()
 	if err != nil {
 		fmt.Printf("Error starting Simple chaincode: %s", err)
 	}
@@ -0,0 +1,435 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// attrRole is the certificate attribute holding the caller's roles, comma
+// separated when there are several
+const attrRole = "role"
+
+// Roles of the callers
+const (
+	RoleAssembler = "assembler"
+	RolePacker    = "packer"
+	RoleLogistics = "logistics"
+	RoleAuditor   = "auditor"
+	RoleSupplier  = "supplier"
+	RoleAdmin     = "admin"
+)
+
+// Grant allows a role to invoke a function
+type Grant struct {
+	Function string `json:"function"`
+	Role     string `json:"role"`
+}
+
+// defaultGrants are seeded by the migrations, those of the functions added
+// later by the migration adding them
+var defaultGrants = []Grant{
+	{"CreateAssembly", RoleAssembler},
+	{"CreateAssembly", RoleAdmin},
+	{"CreateAssemblyFromJSON", RoleAssembler},
+	{"CreateAssemblyFromJSON", RoleAdmin},
+	{"UpdateAssemblyByID", RoleAssembler},
+	{"UpdateAssemblyByID", RoleAdmin},
+	{"UpdateAssemblyFromJSON", RoleAssembler},
+	{"UpdateAssemblyFromJSON", RoleAdmin},
+	{"PatchAssembly", RoleAssembler},
+	{"PatchAssembly", RoleAdmin},
+	{"CreatePackage", RolePacker},
+	{"CreatePackage", RoleAdmin},
+	{"CreatePackageFromJSON", RolePacker},
+	{"CreatePackageFromJSON", RoleAdmin},
+	{"UpdatePackageByCaseID", RolePacker},
+	{"UpdatePackageByCaseID", RoleAdmin},
+	{"UpdatePackageFromJSON", RolePacker},
+	{"UpdatePackageFromJSON", RoleAdmin},
+	{"PatchPackage", RolePacker},
+	{"PatchPackage", RoleAdmin},
+	{"TransitionPackage", RolePacker},
+	{"TransitionPackage", RoleLogistics},
+	{"TransitionPackage", RoleAdmin},
+	{"RegisterBatch", RoleLogistics},
+	{"RegisterBatch", RoleAdmin},
+	{"SubmitBatchAttestation", RoleSupplier},
+	{"RegisterSupplier", RoleAdmin},
+	{"SetSupplierStatus", RoleAdmin},
+	{"SetBillOfMaterials", RoleAdmin},
+	{"SetDeviceType", RoleAdmin},
+	{"RemoveDeviceType", RoleAdmin},
+	{"OpenRecall", RoleAdmin},
+	{"CloseRecall", RoleAdmin},
+	{"AddStatusTransition", RoleAdmin},
+	{"RemoveStatusTransition", RoleAdmin},
+}
+
+// policyFunctions can only be invoked by admins, whatever the access policy
+// says, so the policy cannot lock the admins out
+var policyFunctions = map[string]bool{
+	"GrantRole":         true,
+	"RevokeRole":        true,
+	"AddOperatorMSP":    true,
+	"RemoveOperatorMSP": true,
+	"ImportLegacyRows":  true,
+	"InitLedger":        true,
+}
+
+// OperatorMSP is an organization operating the plants. The roles and the
+// plant in the certificates of its members are trusted, unlike those issued
+// by the CAs of the other organizations, such as the suppliers.
+type OperatorMSP struct {
+	MspId   string `json:"mspId"`
+	AddedBy string `json:"addedBy"`
+	AddedOn string `json:"addedOn"`
+}
+
+// seedGrants stores the default access policy, keeping the grants already
+// edited by the admins
+func seedGrants(stub shim.ChaincodeStubInterface) error {
+	for _, grant := range defaultGrants {
+		_, err := insertRecord(stub, keyGrant, grantKeys(grant), grant)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedGrantsOf returns a migration storing the default grants of functions
+// added after the access policy was first seeded
+func seedGrantsOf(functions ...string) func(stub shim.ChaincodeStubInterface) error {
+	return func(stub shim.ChaincodeStubInterface) error {
+		for _, grant := range defaultGrants {
+			if !stringsContain(functions, grant.Function) {
+				continue
+			}
+			_, err := insertRecord(stub, keyGrant, grantKeys(grant), grant)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// revokeGrantsOf returns a migration deleting grants removed from the default
+// access policy after it was seeded
+func revokeGrantsOf(grants ...Grant) func(stub shim.ChaincodeStubInterface) error {
+	return func(stub shim.ChaincodeStubInterface) error {
+		for _, grant := range grants {
+			err := deleteRecord(stub, keyGrant, grantKeys(grant))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// grantKeys returns the key attributes of a Grant
+func grantKeys(grant Grant) []string {
+	return []string{grant.Function, grant.Role}
+}
+
+// getGrants returns the grants of a function, all of them when function is empty
+func getGrants(stub shim.ChaincodeStubInterface, function string) ([]Grant, error) {
+	keys := []string{}
+	if function != "" {
+		keys = append(keys, function)
+	}
+
+	grants := []Grant{}
+	err := scanRecords(stub, keyGrant, keys, func(keys []string, value []byte) error {
+		grants = append(grants, Grant{Function: keys[0], Role: keys[1]})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve access policy")
+	}
+	return grants, nil
+}
+
+// getOperatorMSPs returns the operator MSPs, in MSP id order
+func getOperatorMSPs(stub shim.ChaincodeStubInterface) ([]*OperatorMSP, error) {
+	operators := []*OperatorMSP{}
+	err := scanRecords(stub, keyOperatorMSP, []string{}, func(keys []string, value []byte) error {
+		operator := new(OperatorMSP)
+		err := json.Unmarshal(value, operator)
+		if err != nil {
+			return fmt.Errorf("Corrupt operator MSP %s: %s", keys[0], err)
+		}
+		operators = append(operators, operator)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return operators, nil
+}
+
+// newOperatorMSP returns the operator MSP record of mspId, added by the caller
+func newOperatorMSP(ctx contractapi.TransactionContextInterface, mspId string) (*OperatorMSP, error) {
+	operator := &OperatorMSP{MspId: mspId}
+	var err error
+	operator.AddedBy, err = callerName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	operator.AddedOn, err = txTimestamp(ctx.GetStub())
+	if err != nil {
+		return nil, err
+	}
+	return operator, nil
+}
+
+// seedOperatorMSP stores mspId as the first operator MSP of a new ledger. The
+// deployer names it and must be one of its members, so the ledger is not
+// seeded by whoever invokes InitLedger first. Once seeded, mspId may only name
+// an operator MSP.
+func seedOperatorMSP(ctx contractapi.TransactionContextInterface, mspId string) error {
+	stub := ctx.GetStub()
+	operators, err := getOperatorMSPs(stub)
+	if err != nil {
+		return err
+	}
+	if len(operators) > 0 {
+		if mspId == "" {
+			return nil
+		}
+		for _, operator := range operators {
+			if operator.MspId == mspId {
+				return nil
+			}
+		}
+		return fmt.Errorf("MSP %s is not an operator MSP. Operator MSPs are added by AddOperatorMSP.", mspId)
+	}
+
+	if mspId == "" {
+		return errors.New("The first operator MSP must be given to initialise the ledger.")
+	}
+	callerMspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return errors.New("Unable to identify the caller: failed to read the caller MSP.")
+	}
+	if callerMspId != mspId {
+		return fmt.Errorf("The first operator MSP %s must be the MSP of the caller, not %s.", mspId, callerMspId)
+	}
+	operator, err := newOperatorMSP(ctx, mspId)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, keyOperatorMSP, []string{mspId}, operator)
+}
+
+// callerIsOperator reports whether the caller is a member of an operator MSP
+func callerIsOperator(ctx contractapi.TransactionContextInterface) (bool, error) {
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return false, err
+	}
+	return recordExists(ctx.GetStub(), keyOperatorMSP, []string{mspId})
+}
+
+// callerRoles returns the caller's roles. Only the members of the operator
+// MSPs have the roles in their certificate: the members of a supplier MSP
+// have the supplier role and those of the other MSPs none.
+func callerRoles(ctx contractapi.TransactionContextInterface) []string {
+	roles := []string{}
+	operator, err := callerIsOperator(ctx)
+	if err != nil {
+		return roles
+	}
+	if !operator {
+		mspId, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return roles
+		}
+		supplier, err := findSupplierByMSP(ctx.GetStub(), mspId)
+		if err == nil && supplier != nil {
+			roles = append(roles, RoleSupplier)
+		}
+		return roles
+	}
+
+	value, found, err := ctx.GetClientIdentity().GetAttributeValue(attrRole)
+	if err != nil || !found {
+		return roles
+	}
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// checkAccess fails unless one of the caller's roles is granted the function
+func checkAccess(ctx contractapi.TransactionContextInterface, function string) error {
+	roles := callerRoles(ctx)
+
+	allowed := []string{RoleAdmin}
+	if !policyFunctions[function] {
+		grants, err := getGrants(ctx.GetStub(), function)
+		if err != nil {
+			return err
+		}
+		allowed = []string{}
+		for _, grant := range grants {
+			allowed = append(allowed, grant.Role)
+		}
+	}
+
+	for _, role := range roles {
+		if stringsContain(allowed, role) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Permission denied. %s requires one of the roles [%s], caller has [%s].", function, strings.Join(allowed, ", "), strings.Join(roles, ", "))
+}
+
+// beforeTransaction runs before every transaction and restricts all of them
+// but the Get queries to the roles of the access policy. InitLedger is open
+// until it seeds the first operator MSP, then reserved to admins.
+func beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	// Drop the contract name of namespaced calls such as TnT:CreateAssembly
+	function = function[strings.LastIndex(function, ":")+1:]
+
+	if strings.HasPrefix(function, "Get") {
+		return nil
+	}
+	if function == "InitLedger" {
+		operators, err := getOperatorMSPs(ctx.GetStub())
+		if err != nil || len(operators) == 0 {
+			return err
+		}
+	}
+	return checkAccess(ctx, function)
+}
+
+// newGrant validates the function, role arguments of GrantRole and RevokeRole
+func newGrant(function string, role string) (Grant, error) {
+	grant := Grant{Function: function, Role: role}
+	if grant.Function == "" || grant.Role == "" {
+		return grant, errors.New("Function and role must not be empty.")
+	}
+	if policyFunctions[grant.Function] {
+		return grant, fmt.Errorf("%s is reserved to admins.", grant.Function)
+	}
+	return grant, nil
+}
+
+//Admin API to allow a role to invoke a function
+func (t *TnT) GrantRole(ctx contractapi.TransactionContextInterface, function string, role string) error {
+	grant, err := newGrant(function, role)
+	if err != nil {
+		return err
+	}
+
+	ok, err := insertRecord(ctx.GetStub(), keyGrant, grantKeys(grant), grant)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("Grant already exists.")
+	}
+	return nil
+}
+
+//Admin API to stop a role from invoking a function
+func (t *TnT) RevokeRole(ctx contractapi.TransactionContextInterface, function string, role string) error {
+	grant, err := newGrant(function, role)
+	if err != nil {
+		return err
+	}
+
+	return deleteRecord(ctx.GetStub(), keyGrant, grantKeys(grant))
+}
+
+//Admin API to trust the roles and the plant in the certificates of the
+//members of an MSP
+func (t *TnT) AddOperatorMSP(ctx contractapi.TransactionContextInterface, mspId string) error {
+	stub := ctx.GetStub()
+
+	if mspId == "" {
+		return errors.New("MSP id must not be empty.")
+	}
+	supplier, err := findSupplierByMSP(stub, mspId)
+	if err != nil {
+		return err
+	}
+	if supplier != nil {
+		return fmt.Errorf("MSP %s is the MSP of supplier %s.", mspId, supplier.SupplierId)
+	}
+
+	operator, err := newOperatorMSP(ctx, mspId)
+	if err != nil {
+		return err
+	}
+	ok, err := insertRecord(stub, keyOperatorMSP, []string{mspId}, operator)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Operator MSP %s already exists.", mspId)
+	}
+	return nil
+}
+
+//Admin API to stop trusting the certificates of the members of an MSP. The
+//last operator MSP cannot be removed.
+func (t *TnT) RemoveOperatorMSP(ctx contractapi.TransactionContextInterface, mspId string) error {
+	stub := ctx.GetStub()
+
+	operators, err := getOperatorMSPs(stub)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, operator := range operators {
+		found = found || operator.MspId == mspId
+	}
+	if !found {
+		return fmt.Errorf("Operator MSP %s not found.", mspId)
+	}
+	if len(operators) == 1 {
+		return errors.New("The last operator MSP cannot be removed.")
+	}
+	return deleteRecord(stub, keyOperatorMSP, []string{mspId})
+}
+
+//get the operator MSPs, whose members' roles and plant are trusted
+func (t *TnT) GetOperatorMSPs(ctx contractapi.TransactionContextInterface) ([]*OperatorMSP, error) {
+	return getOperatorMSPs(ctx.GetStub())
+}
+
+//get the roles allowed to invoke a function, of every function when it is empty
+func (t *TnT) GetAccessPolicy(ctx contractapi.TransactionContextInterface, function string) ([]Grant, error) {
+	return getGrants(ctx.GetStub(), function)
+}
@@ -0,0 +1,198 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestBeforeTransaction(t *testing.T) {
+	tests := []struct {
+		function string
+		roles    string
+		wantErr  string
+	}{
+		{"CreateAssembly", RoleAssembler, ""},
+		{"CreateAssembly", RoleAdmin, ""},
+		{"CreateAssembly", RolePacker, "Permission denied. CreateAssembly requires one of the roles [admin, assembler], caller has [packer]."},
+		{"CreateAssembly", "", "Permission denied. CreateAssembly requires one of the roles [admin, assembler], caller has []."},
+		{"TnT:CreatePackage", "auditor, packer", ""},
+		{"TransitionPackage", RoleLogistics, ""},
+		{"GetAllAssembly", "", ""},
+		{"InitLedger", RoleAdmin, ""},
+		{"InitLedger", RolePacker, "Permission denied. InitLedger requires one of the roles [admin], caller has [packer]."},
+		{"OpenRecall", RoleAssembler, "Permission denied. OpenRecall requires one of the roles [admin], caller has [assembler]."},
+		{"GrantRole", RoleAdmin, ""},
+		{"GrantRole", RoleAssembler, "Permission denied. GrantRole requires one of the roles [admin], caller has [assembler]."},
+		{"ImportLegacyRows", RolePacker, "Permission denied. ImportLegacyRows requires one of the roles [admin], caller has [packer]."},
+		{"Unknown", RoleAdmin, "Permission denied. Unknown requires one of the roles [], caller has [admin]."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.function+" by "+test.roles, func(t *testing.T) {
+			l.stub.function = test.function
+			err := beforeTransaction(l.caller("user1", "PLANT1", test.roles))
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestGrantAndRevokeRole(t *testing.T) {
+	l := newTestLedger(t)
+	auditor := l.caller("auditor1", "", RoleAuditor)
+	l.stub.function = "CloseRecall"
+
+	expectError(t, beforeTransaction(auditor), "Permission denied. CloseRecall requires one of the roles [admin], caller has [auditor].")
+
+	l.mustInvoke("GrantRole", func() error { return l.tnt.GrantRole(l.admin(), "CloseRecall", RoleAuditor) })
+	if err := beforeTransaction(auditor); err != nil {
+		t.Errorf("granted role denied: %s", err)
+	}
+	grants, err := l.tnt.GetAccessPolicy(auditor, "CloseRecall")
+	if err != nil || len(grants) != 2 || grants[1] != (Grant{"CloseRecall", RoleAuditor}) {
+		t.Errorf("GetAccessPolicy: %v %v", grants, err)
+	}
+
+	l.mustInvoke("RevokeRole", func() error { return l.tnt.RevokeRole(l.admin(), "CloseRecall", RoleAuditor) })
+	expectError(t, beforeTransaction(auditor), "Permission denied. CloseRecall requires one of the roles [admin], caller has [auditor].")
+}
+
+func TestGrantRoleErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		role     string
+		wantErr  string
+	}{
+		{"duplicate", "CreateAssembly", RoleAssembler, "Grant already exists."},
+		{"empty role", "CreateAssembly", "", "Function and role must not be empty."},
+		{"policy function", "RevokeRole", RoleAssembler, "RevokeRole is reserved to admins."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error { return l.tnt.GrantRole(l.admin(), test.function, test.role) })
+			expectError(t, err, test.wantErr)
+		})
+	}
+
+	err := l.invoke(func() error { return l.tnt.RevokeRole(l.admin(), "ImportLegacyRows", RoleAdmin) })
+	expectError(t, err, "ImportLegacyRows is reserved to admins.")
+
+	grants, err := l.tnt.GetAccessPolicy(l.admin(), "")
+	if err != nil || len(grants) != len(defaultGrants) {
+		t.Errorf("expected the %d default grants, got %d: %v", len(defaultGrants), len(grants), err)
+	}
+}
+
+// mspCaller returns the context of a transaction submitted by a member of
+// mspId with the given plant and comma separated roles
+func (l *testLedger) mspCaller(mspId string, plant string, roles string) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(&testIdentity{
+		mspId: mspId,
+		attrs: map[string]string{attrEnrollmentId: "user1", attrPlant: plant, attrRole: roles},
+	})
+	return ctx
+}
+
+func TestOperatorMSPs(t *testing.T) {
+	l := newTestLedger(t)
+	l.registerSupplier("LUMEN", newECDSASupplierKey(t), "led")
+
+	// Only the roles and the plant of the operator MSPs are trusted
+	tests := []struct {
+		name    string
+		mspId   string
+		roles   string
+		plant   string
+		wantErr string
+	}{
+		{"operator", "PlantMSP", RoleAdmin, hqPlant, ""},
+		{"unknown MSP", "RogueMSP", RoleAdmin, hqPlant, "Permission denied. GrantRole requires one of the roles [admin], caller has []."},
+		{"supplier MSP", "LUMENMSP", RoleAdmin + "," + RoleSupplier, hqPlant, "Permission denied. GrantRole requires one of the roles [admin], caller has [supplier]."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := l.mspCaller(test.mspId, test.plant, test.roles)
+			l.stub.function = "GrantRole"
+			err := beforeTransaction(ctx)
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				expectError(t, checkPlantRead(ctx, "PLANT1"), `Permission denied. Caller of plant "" cannot read assemblies of plant "PLANT1".`)
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := checkPlantRead(ctx, "PLANT1"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+
+	// The MSP initialising the ledger is the first operator MSP
+	operators, err := l.tnt.GetOperatorMSPs(l.admin())
+	if err != nil {
+		t.Fatalf("GetOperatorMSPs: %s", err)
+	}
+	if len(operators) != 1 || operators[0].MspId != "PlantMSP" || operators[0].AddedBy != "admin1@PlantMSP" {
+		t.Errorf("unexpected operator MSPs %+v", operators)
+	}
+
+	l.mustInvoke("AddOperatorMSP", func() error { return l.tnt.AddOperatorMSP(l.admin(), "HQMSP") })
+	l.stub.function = "GrantRole"
+	if err := beforeTransaction(l.mspCaller("HQMSP", hqPlant, RoleAdmin)); err != nil {
+		t.Errorf("member of a new operator MSP denied: %s", err)
+	}
+
+	key := newECDSASupplierKey(t).publicKey()
+	errs := []struct {
+		name    string
+		fn      func() error
+		wantErr string
+	}{
+		{"add twice", func() error { return l.tnt.AddOperatorMSP(l.admin(), "HQMSP") }, "Operator MSP HQMSP already exists."},
+		{"add supplier MSP", func() error { return l.tnt.AddOperatorMSP(l.admin(), "LUMENMSP") }, "MSP LUMENMSP is the MSP of supplier LUMEN."},
+		{"add empty", func() error { return l.tnt.AddOperatorMSP(l.admin(), "") }, "MSP id must not be empty."},
+		{"remove unknown", func() error { return l.tnt.RemoveOperatorMSP(l.admin(), "RogueMSP") }, "Operator MSP RogueMSP not found."},
+		{"operator supplier", func() error {
+			return l.tnt.RegisterSupplier(l.admin(), "ACME", "Acme Ltd", "HQMSP", []string{"led"}, key)
+		}, "MSP HQMSP is an operator MSP."},
+	}
+	for _, test := range errs {
+		t.Run(test.name, func(t *testing.T) {
+			expectError(t, l.invoke(test.fn), test.wantErr)
+		})
+	}
+
+	l.mustInvoke("RemoveOperatorMSP", func() error { return l.tnt.RemoveOperatorMSP(l.admin(), "HQMSP") })
+	err = l.invoke(func() error { return l.tnt.RemoveOperatorMSP(l.admin(), "PlantMSP") })
+	expectError(t, err, "The last operator MSP cannot be removed.")
+}
@@ -0,0 +1,458 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateAssembly(t *testing.T) {
+	tests := []struct {
+		name    string
+		plant   string
+		status  string
+		wantErr string
+	}{
+		{"created", "PLANT1", AssemblyCreated, ""},
+		{"illegal initial status", "PLANT1", AssemblyQAPassed, `Illegal initial AssemblyLine status "QA-Passed". Allowed: [Created].`},
+		{"other plant", "PLANT2", AssemblyCreated, `Permission denied. Caller of plant "PLANT1" cannot write assemblies of plant "PLANT2".`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			ctx := l.caller("op1", "PLANT1", RoleAssembler)
+
+			var result *AssemblyCreateResult
+			err := l.invoke(func() (err error) {
+				result, err = l.tnt.CreateAssembly(ctx, "SN-1", DeviceTypeHolder, "FIL-1", "LED-1", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", test.plant, test.status, "L1")
+				return err
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				all, _ := l.tnt.GetAllAssembly(l.admin())
+				if len(all) != 0 {
+					t.Errorf("failed create stored %d assemblies", len(all))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateAssembly: %s", err)
+			}
+
+			if !strings.HasPrefix(result.AssemblyId, "ASM-PLANT1-L1-0000000001-") {
+				t.Errorf("unexpected assembly id %s", result.AssemblyId)
+			}
+			assemblies, err := l.tnt.GetAssemblyByID(ctx, result.AssemblyId)
+			if err != nil {
+				t.Fatalf("GetAssemblyByID: %s", err)
+			}
+			assembly := assemblies[0]
+			if assembly.AssemblyCreatedBy != "op1@PlantMSP" || assembly.AssemblyLastUpdatedBy != "op1@PlantMSP" {
+				t.Errorf("expected creator op1@PlantMSP, got %s and %s", assembly.AssemblyCreatedBy, assembly.AssemblyLastUpdatedBy)
+			}
+			if assembly.AssemblyCreationDate == "" || assembly.CaseId != "" {
+				t.Errorf("unexpected creation date %q or case %q", assembly.AssemblyCreationDate, assembly.CaseId)
+			}
+		})
+	}
+}
+
+func TestCreateAssemblyMintsDistinctIds(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+
+	first := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+	second := l.createAssembly(ctx, "SN-2", DeviceTypeHolder, "PLANT1")
+	if first == second {
+		t.Fatalf("both assemblies got id %s", first)
+	}
+	if !strings.Contains(second, "-0000000002-") {
+		t.Errorf("expected the second id of the sequence, got %s", second)
+	}
+}
+
+func TestCreateAssemblyFromJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		wantErr  string
+	}{
+		{
+			"valid",
+			`{"assemblyId": "ignored", "deviceSerialNo": "SN-1", "deviceType": "Holder", "ledBatchId": "LED-1", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created", "assemblyLine": "L7"}`,
+			"",
+		},
+		{
+			"empty document",
+			`{}`,
+			"Invalid assembly: deviceSerialNo is missing; deviceType is missing; manufacturingPlant is missing; assemblyStatus is missing.",
+		},
+		{
+			"not an object",
+			`["SN-1", "Holder"]`,
+			"Invalid assembly: document is not a JSON object.",
+		},
+		{
+			"unknown and malformed fields",
+			`{"colour": "red", "deviceSerialNo": 12, "deviceType": "", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created"}`,
+			"Invalid assembly: colour is not a known field; deviceSerialNo must be a string; deviceType must not be empty.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			ctx := l.caller("op1", "PLANT1", RoleAssembler)
+
+			var result *AssemblyCreateResult
+			err := l.invoke(func() (err error) {
+				result, err = l.tnt.CreateAssemblyFromJSON(ctx, test.document)
+				return err
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateAssemblyFromJSON: %s", err)
+			}
+
+			if !strings.HasPrefix(result.AssemblyId, "ASM-PLANT1-L7-") {
+				t.Errorf("unexpected assembly id %s", result.AssemblyId)
+			}
+			assembly := l.assembly(result.AssemblyId)
+			if assembly.DeviceSerialNo != "SN-1" || assembly.LedBatchId != "LED-1" || assembly.FilamentBatchId != "" {
+				t.Errorf("unexpected assembly %+v", assembly)
+			}
+		})
+	}
+}
+
+func TestPatchAssembly(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr string
+		check   func(t *testing.T, assembly *AssemblyLine)
+	}{
+		{
+			name:  "status",
+			patch: `{"assemblyStatus": "InAssembly"}`,
+			check: func(t *testing.T, assembly *AssemblyLine) {
+				if assembly.AssemblyStatus != AssemblyInAssembly || assembly.LedBatchId != "LED-1" {
+					t.Errorf("unexpected assembly %+v", assembly)
+				}
+				if assembly.AssemblyCreatedBy != "op1@PlantMSP" || assembly.AssemblyLastUpdatedBy != "op2@PlantMSP" {
+					t.Errorf("expected created by op1 and updated by op2, got %s and %s", assembly.AssemblyCreatedBy, assembly.AssemblyLastUpdatedBy)
+				}
+			},
+		},
+		{
+			name:  "null clears a field",
+			patch: `{"ledBatchId": null}`,
+			check: func(t *testing.T, assembly *AssemblyLine) {
+				if assembly.LedBatchId != "" {
+					t.Errorf("expected no led batch, got %s", assembly.LedBatchId)
+				}
+			},
+		},
+		{
+			name:    "immutable field",
+			patch:   `{"assemblyCreatedBy": "mallory", "caseId": "CASE-1"}`,
+			wantErr: "Invalid assembly patch: assemblyCreatedBy cannot be changed; caseId cannot be changed.",
+		},
+		{
+			name:    "required field cleared",
+			patch:   `{"deviceType": null, "wireBatchId": 3}`,
+			wantErr: "Invalid assembly patch: deviceType must not be empty; wireBatchId must be a string or null.",
+		},
+		{
+			name:    "illegal transition",
+			patch:   `{"assemblyStatus": "Shipped"}`,
+			wantErr: `Illegal AssemblyLine status transition from "Created" to "Shipped". Allowed: [InAssembly].`,
+		},
+		{
+			name:    "other plant",
+			patch:   `{"manufacturingPlant": "PLANT2"}`,
+			wantErr: `Permission denied. Caller of plant "PLANT1" cannot write assemblies of plant "PLANT2".`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			assemblyId := l.createAssembly(l.caller("op1", "PLANT1", RoleAssembler), "SN-1", DeviceTypeHolder, "PLANT1")
+
+			err := l.invoke(func() error {
+				return l.tnt.PatchAssembly(l.caller("op2", "PLANT1", RoleAssembler), assemblyId, test.patch)
+			})
+			history, _ := getHistory(l.stub, objectAssembly, assemblyId)
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				if len(history) != 1 {
+					t.Errorf("failed patch recorded %d versions", len(history))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PatchAssembly: %s", err)
+			}
+			if len(history) != 2 {
+				t.Errorf("expected 2 versions, got %d", len(history))
+			}
+			test.check(t, l.assembly(assemblyId))
+		})
+	}
+}
+
+func TestPatchAssemblyWithoutChanges(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+
+	l.mustInvoke("PatchAssembly", func() error {
+		return l.tnt.PatchAssembly(ctx, assemblyId, `{"assemblyId": "`+assemblyId+`", "deviceSerialNo": "SN-1"}`)
+	})
+	history, _ := getHistory(l.stub, objectAssembly, assemblyId)
+	if len(history) != 1 {
+		t.Errorf("patch without changes recorded %d versions", len(history))
+	}
+
+	err := l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, "ASM-NONE", `{"deviceSerialNo": "SN-2"}`)
+	})
+	expectError(t, err, "Assembly ASM-NONE not found.")
+}
+
+func TestAssemblyQueries(t *testing.T) {
+	l := newTestLedger(t)
+	op1 := l.caller("op1", "PLANT1", RoleAssembler)
+	op2 := l.caller("op2", "PLANT2", RoleAssembler)
+	auditor := l.caller("auditor1", "", RoleAuditor)
+
+	plant1 := []string{}
+	for _, serialNo := range []string{"SN-1", "SN-2", "SN-3"} {
+		plant1 = append(plant1, l.createAssembly(op1, serialNo, DeviceTypeHolder, "PLANT1"))
+	}
+	plant2 := l.createAssembly(op2, "SN-4", DeviceTypeCharger, "PLANT2")
+	l.moveAssembly(op1, plant1[0], AssemblyInAssembly)
+
+	tests := []struct {
+		name    string
+		query   func() ([]*AssemblyLine, error)
+		want    int
+		wantErr string
+	}{
+		{"all by auditor", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssembly(auditor) }, 4, ""},
+		{"all by operator", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssembly(op2) }, 1, ""},
+		{"by id", func() ([]*AssemblyLine, error) { return l.tnt.GetAssemblyByID(op1, plant1[1]) }, 1, ""},
+		{"by unknown id", func() ([]*AssemblyLine, error) { return l.tnt.GetAssemblyByID(op1, "ASM-NONE") }, 0, "Assembly ASM-NONE not found."},
+		{"by id of other plant", func() ([]*AssemblyLine, error) { return l.tnt.GetAssemblyByID(op1, plant2) }, 0, `Permission denied. Caller of plant "PLANT1" cannot read assemblies of plant "PLANT2".`},
+		{"by status", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssemblyByStatus(auditor, AssemblyCreated) }, 3, ""},
+		{"by status of operator", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssemblyByStatus(op1, AssemblyCreated) }, 2, ""},
+		{"by unused status", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssemblyByStatus(auditor, AssemblyShipped) }, 0, ""},
+		{"by plant", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssemblyByPlant(op1, "PLANT1") }, 3, ""},
+		{"by other plant", func() ([]*AssemblyLine, error) { return l.tnt.GetAllAssemblyByPlant(op1, "PLANT2") }, 0, `Permission denied. Caller of plant "PLANT1" cannot read assemblies of plant "PLANT2".`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assemblies, err := test.query()
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("query: %s", err)
+			}
+			if len(assemblies) != test.want {
+				t.Errorf("expected %d assemblies, got %d", test.want, len(assemblies))
+			}
+		})
+	}
+}
+
+func TestAssemblyPages(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	for _, serialNo := range []string{"SN-1", "SN-2", "SN-3", "SN-4", "SN-5"} {
+		l.createAssembly(ctx, serialNo, DeviceTypeHolder, "PLANT1")
+	}
+
+	tests := []struct {
+		name  string
+		query func(bookmark string) (*AssemblyPage, error)
+	}{
+		{"all", func(bookmark string) (*AssemblyPage, error) { return l.tnt.GetAllAssemblyPage(ctx, 2, bookmark) }},
+		{"by status", func(bookmark string) (*AssemblyPage, error) {
+			return l.tnt.GetAllAssemblyByStatusPage(ctx, AssemblyCreated, 2, bookmark)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := []int{}
+			seen := map[string]bool{}
+			bookmark := ""
+			for {
+				page, err := test.query(bookmark)
+				if err != nil {
+					t.Fatalf("query: %s", err)
+				}
+				if page.Count != len(page.Items) {
+					t.Errorf("count %d of a page of %d items", page.Count, len(page.Items))
+				}
+				for _, assembly := range page.Items {
+					if seen[assembly.AssemblyId] {
+						t.Errorf("assembly %s on two pages", assembly.AssemblyId)
+					}
+					seen[assembly.AssemblyId] = true
+				}
+				counts = append(counts, page.Count)
+				if page.NextBookmark == "" {
+					break
+				}
+				bookmark = page.NextBookmark
+			}
+			if len(seen) != 5 || len(counts) != 3 {
+				t.Errorf("expected 5 assemblies on 3 pages, got %d on pages %v", len(seen), counts)
+			}
+		})
+	}
+
+	_, err := l.tnt.GetAllAssemblyPage(ctx, -1, "")
+	expectError(t, err, "Invalid page size -1. Expecting 1 to 1000.")
+	_, err = l.tnt.GetAllAssemblyByStatusPage(ctx, AssemblyCreated, maxPageSize+1, "")
+	expectError(t, err, "Invalid page size 1001. Expecting 1 to 1000.")
+}
+
+func TestGetAssemblyHistory(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+	l.moveAssembly(ctx, assemblyId, AssemblyInAssembly)
+
+	history, err := l.tnt.GetAssemblyHistory(ctx, assemblyId)
+	if err != nil {
+		t.Fatalf("GetAssemblyHistory: %s", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(history))
+	}
+	if history[0].Version != 1 || history[0].Record.AssemblyStatus != AssemblyCreated {
+		t.Errorf("unexpected first version %+v", history[0])
+	}
+
+	changed := map[string]FieldChange{}
+	for _, change := range history[1].Changes {
+		changed[change.Field] = change
+	}
+	if change := changed["assemblyStatus"]; change.From != AssemblyCreated || change.To != AssemblyInAssembly {
+		t.Errorf("unexpected status change %+v", change)
+	}
+	if _, ok := changed["deviceSerialNo"]; ok {
+		t.Errorf("unchanged deviceSerialNo reported as changed")
+	}
+
+	// Map fields read as JSON, and as empty when they are
+	created := map[string]FieldChange{}
+	for _, change := range history[0].Changes {
+		created[change.Field] = change
+	}
+	if change := created["components"]; change.From != "" || change.To != `{"adaptor":"ADP-1","casing":"CASE-1","circuitBoard":"CB-1","filament":"FIL-1","led":"LED-1","stickPod":"SP-1","wire":"WIRE-1"}` {
+		t.Errorf("unexpected components change %+v", change)
+	}
+	var result *AssemblyCreateResult
+	l.mustInvoke("CreateAssembly", func() (err error) {
+		result, err = l.tnt.CreateAssembly(ctx, "SN-2", DeviceTypeHolder, "", "", "", "", "", "", "", "PLANT1", AssemblyCreated, "L1")
+		return err
+	})
+	history, err = l.tnt.GetAssemblyHistory(ctx, result.AssemblyId)
+	if err != nil {
+		t.Fatalf("GetAssemblyHistory: %s", err)
+	}
+	for _, change := range history[0].Changes {
+		if change.Field == "components" || change.Field == "componentQuantities" {
+			t.Errorf("empty %s reported as changed: %+v", change.Field, change)
+		}
+	}
+
+	_, err = l.tnt.GetAssemblyHistory(l.caller("op2", "PLANT2", RoleAssembler), assemblyId)
+	expectError(t, err, `Permission denied. Caller of plant "PLANT2" cannot read assemblies of plant "PLANT1".`)
+}
+
+func TestDeviceSerialNoIsUnique(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	first := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+	second := l.createAssembly(ctx, "SN-2", DeviceTypeHolder, "PLANT1")
+
+	err := l.invoke(func() error {
+		_, err := l.tnt.CreateAssembly(ctx, "SN-1", DeviceTypeHolder, "", "", "", "", "", "", "", "PLANT1", AssemblyCreated, "L1")
+		return err
+	})
+	expectError(t, err, "Device serial number SN-1 is already used by assembly "+first+".")
+
+	err = l.invoke(func() error {
+		return l.tnt.UpdateAssemblyByID(ctx, second, "SN-1", DeviceTypeHolder, "", "", "", "", "", "", "", "PLANT1", AssemblyCreated, "")
+	})
+	expectError(t, err, "Device serial number SN-1 is already used by assembly "+first+".")
+
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, second, `{"deviceSerialNo": "SN-1"}`)
+	})
+	expectError(t, err, "Device serial number SN-1 is already used by assembly "+first+".")
+
+	// An assembly keeps its own serial number, and gives it up when it is
+	// renumbered
+	l.moveAssembly(ctx, first, AssemblyInAssembly)
+	l.mustInvoke("PatchAssembly", func() error {
+		return l.tnt.PatchAssembly(ctx, first, `{"deviceSerialNo": "SN-1b"}`)
+	})
+	l.mustInvoke("PatchAssembly", func() error {
+		return l.tnt.PatchAssembly(ctx, second, `{"deviceSerialNo": "SN-1"}`)
+	})
+}
+
+func TestGetAssemblyBySerialNo(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+
+	assembly, err := l.tnt.GetAssemblyBySerialNo(l.caller("support1", hqPlant, ""), "SN-1")
+	if err != nil {
+		t.Fatalf("GetAssemblyBySerialNo: %s", err)
+	}
+	if assembly.AssemblyId != assemblyId {
+		t.Errorf("expected assembly %s, got %s", assemblyId, assembly.AssemblyId)
+	}
+
+	_, err = l.tnt.GetAssemblyBySerialNo(ctx, "SN-NONE")
+	expectError(t, err, "No assembly with device serial number SN-NONE.")
+	_, err = l.tnt.GetAssemblyBySerialNo(l.caller("op2", "PLANT2", RoleAssembler), "SN-1")
+	expectError(t, err, `Permission denied. Caller of plant "PLANT2" cannot read assemblies of plant "PLANT1".`)
+
+	// Serial numbers used twice before they were unique
+	l.mustInvoke("putIndex", func() error { return putIndex(l.stub, assemblyBySerial.Name, "SN-1", "ASM-OLD") })
+	_, err = l.tnt.GetAssemblyBySerialNo(ctx, "SN-1")
+	expectError(t, err, "Device serial number SN-1 is used by several assemblies: [ASM-OLD, "+assemblyId+"].")
+}
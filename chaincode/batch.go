@@ -0,0 +1,245 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ComponentBatch is a batch of components received from a supplier. Every
+// assembly built with the batch consumes one component of it.
+type ComponentBatch struct {
+	BatchId       string `json:"batchId"`
+	ComponentType string `json:"componentType"`
+	Supplier      string `json:"supplier"`
+	// ReceivedDate is the shipping date of the batches attested by a supplier
+	ReceivedDate     string `json:"receivedDate"`
+	QuantityReceived int    `json:"quantityReceived"`
+	QuantityConsumed int    `json:"quantityConsumed"`
+	// CertificateHash is the SHA-256 of the supplier's certificate of
+	// conformance, in hex
+	CertificateHash string `json:"certificateHash"`
+	RegisteredBy    string `json:"registeredBy"`
+	RegisteredOn    string `json:"registeredOn"`
+	// SupplierId is the registered supplier that attested the batch, empty for
+	// the batches registered by the plants. Attestation is the document it
+	// signed and Signature its signature, so the link can be verified again.
+	SupplierId  string `json:"supplierId"`
+	Attestation string `json:"attestation"`
+	Signature   string `json:"signature"`
+}
+
+// getBatch reads a batch by its component type and id, returning nil when it
+// is not registered
+func getBatch(stub shim.ChaincodeStubInterface, componentType string, batchId string) (*ComponentBatch, error) {
+	batch := new(ComponentBatch)
+	ok, err := getRecord(stub, keyBatch, []string{componentType, batchId}, batch)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// consumeBatches moves the components an assembly consumes from the batches
+// of its previous version, nil for a new assembly, to the current ones. The
+// batches it takes more components from must be registered, not under recall
+// and not exhausted.
+func consumeBatches(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	// Sum the changes of each batch first, since a transaction does not read
+	// its own writes
+	type batchKey struct{ componentType, batchId string }
+	deltas := map[batchKey]int{}
+	keys := []batchKey{}
+	add := func(a *AssemblyLine, sign int) {
+		for _, componentType := range sortedKeys(a.Components) {
+			key := batchKey{componentType, a.Components[componentType]}
+			if _, ok := deltas[key]; !ok {
+				keys = append(keys, key)
+			}
+			deltas[key] += sign * componentQuantity(a, componentType)
+		}
+	}
+	if previous != nil {
+		add(previous, -1)
+	}
+	add(current, 1)
+
+	for _, key := range keys {
+		delta := deltas[key]
+		if delta == 0 {
+			continue
+		}
+		batch, err := getBatch(stub, key.componentType, key.batchId)
+		if err != nil {
+			return err
+		}
+
+		// Give the components back to the batch they were taken from.
+		// Assemblies imported from the legacy chaincode used unregistered
+		// batches.
+		if delta < 0 {
+			if batch == nil || batch.QuantityConsumed == 0 {
+				continue
+			}
+			batch.QuantityConsumed += delta
+			if batch.QuantityConsumed < 0 {
+				batch.QuantityConsumed = 0
+			}
+			err = putRecord(stub, keyBatch, []string{key.componentType, key.batchId}, batch)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if batch == nil {
+			return fmt.Errorf("Unknown %s batch %s.", key.componentType, key.batchId)
+		}
+		recallId, err := getOpenRecallId(stub, key.componentType, key.batchId)
+		if err != nil {
+			return err
+		}
+		if recallId != "" {
+			return fmt.Errorf("%s batch %s is under recall %s.", key.componentType, key.batchId, recallId)
+		}
+		if batch.SupplierId != "" {
+			supplier, err := getSupplier(stub, batch.SupplierId)
+			if err != nil {
+				return err
+			}
+			if supplier == nil || supplier.Status != SupplierActive {
+				return fmt.Errorf("%s batch %s is from suspended supplier %s.", key.componentType, key.batchId, batch.SupplierId)
+			}
+		}
+		if batch.QuantityConsumed+delta > batch.QuantityReceived {
+			return fmt.Errorf("%s batch %s is exhausted: %d of %d used.", key.componentType, key.batchId, batch.QuantityConsumed, batch.QuantityReceived)
+		}
+		batch.QuantityConsumed += delta
+		err = putRecord(stub, keyBatch, []string{key.componentType, key.batchId}, batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// componentQuantity returns the quantity an assembly takes from the batch of a
+// component type, one when it was built before the bill of materials
+func componentQuantity(a *AssemblyLine, componentType string) int {
+	if quantity := a.ComponentQuantities[componentType]; quantity > 0 {
+		return quantity
+	}
+	return 1
+}
+
+// checkBatchContents validates the quantity and certificate hash of a new batch
+func checkBatchContents(quantity int, certificateHash string) error {
+	if quantity < 1 {
+		return fmt.Errorf("Invalid quantity %d. Expecting at least 1.", quantity)
+	}
+	if hash, err := hex.DecodeString(certificateHash); err != nil || len(hash) != 32 {
+		return errors.New("Invalid certificate hash. Expecting a hex SHA-256.")
+	}
+	return nil
+}
+
+// insertBatch stores a new batch
+func insertBatch(stub shim.ChaincodeStubInterface, batch *ComponentBatch) error {
+	ok, err := insertRecord(stub, keyBatch, []string{batch.ComponentType, batch.BatchId}, batch)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s batch %s already exists.", batch.ComponentType, batch.BatchId)
+	}
+	return nil
+}
+
+//API to register a batch of components received from a supplier that is not
+//onboarded. The batches of the onboarded suppliers are registered by their
+//attestations. The certificate hash is the SHA-256 of the certificate of
+//conformance, in hex.
+func (t *TnT) RegisterBatch(ctx contractapi.TransactionContextInterface, componentType string, batchId string, supplier string, receivedDate string, quantityReceived int, certificateHash string) error {
+	stub := ctx.GetStub()
+
+	_componentType, err := getComponentType(stub, componentType)
+	if err != nil {
+		return err
+	}
+	if batchId == "" || supplier == "" {
+		return errors.New("Batch id and supplier must not be empty.")
+	}
+	if _, err := time.Parse("2006-01-02", receivedDate); err != nil {
+		return fmt.Errorf("Invalid received date %q. Expecting YYYY-MM-DD.", receivedDate)
+	}
+	err = checkBatchContents(quantityReceived, certificateHash)
+	if err != nil {
+		return err
+	}
+	err = checkPlantBatch(stub, _componentType, supplier)
+	if err != nil {
+		return err
+	}
+
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+	_time, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	return insertBatch(stub, &ComponentBatch{
+		BatchId:          batchId,
+		ComponentType:    _componentType,
+		Supplier:         supplier,
+		ReceivedDate:     receivedDate,
+		QuantityReceived: quantityReceived,
+		CertificateHash:  certificateHash,
+		RegisteredBy:     _caller,
+		RegisteredOn:     _time,
+	})
+}
+
+//get a component Batch with its remaining quantity
+func (t *TnT) GetBatch(ctx contractapi.TransactionContextInterface, componentType string, batchId string) (*ComponentBatch, error) {
+	stub := ctx.GetStub()
+
+	_componentType, err := getComponentType(stub, componentType)
+	if err != nil {
+		return nil, err
+	}
+
+	_batch, err := getBatch(stub, _componentType, batchId)
+	if err != nil {
+		return nil, err
+	}
+	if _batch == nil {
+		return nil, fmt.Errorf("%s batch %s not found.", _componentType, batchId)
+	}
+	return _batch, nil
+}
@@ -0,0 +1,130 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegisterBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		componentType string
+		batchId       string
+		receivedDate  string
+		quantity      int
+		hash          string
+		wantErr       string
+	}{
+		{"registered", "LED", "LED-7", "2024-02-01", 50, testCertificateHash, ""},
+		{"duplicate", "led", "LED-1", "2024-02-01", 50, testCertificateHash, "led batch LED-1 already exists."},
+		{"unknown component", "glass", "GL-1", "2024-02-01", 50, testCertificateHash, `Unknown component type "glass". Expecting one of [filament, led, circuitBoard, wire, casing, adaptor, stickPod].`},
+		{"no batch id", "led", "", "2024-02-01", 50, testCertificateHash, "Batch id and supplier must not be empty."},
+		{"bad date", "led", "LED-7", "Feb 1", 50, testCertificateHash, `Invalid received date "Feb 1". Expecting YYYY-MM-DD.`},
+		{"no quantity", "led", "LED-7", "2024-02-01", 0, testCertificateHash, "Invalid quantity 0. Expecting at least 1."},
+		{"bad hash", "led", "LED-7", "2024-02-01", 50, "cafe", "Invalid certificate hash. Expecting a hex SHA-256."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				return l.tnt.RegisterBatch(l.caller("clerk1", "PLANT1", RoleLogistics), test.componentType, test.batchId, "ACME", test.receivedDate, test.quantity, test.hash)
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("RegisterBatch: %s", err)
+			}
+
+			batch, err := l.tnt.GetBatch(l.admin(), test.componentType, test.batchId)
+			if err != nil {
+				t.Fatalf("GetBatch: %s", err)
+			}
+			if batch.ComponentType != "led" || batch.QuantityReceived != test.quantity || batch.QuantityConsumed != 0 || batch.RegisteredBy != "clerk1@PlantMSP" {
+				t.Errorf("unexpected batch %+v", batch)
+			}
+		})
+	}
+
+	_, err := l.tnt.GetBatch(l.admin(), "led", "LED-NONE")
+	expectError(t, err, "led batch LED-NONE not found.")
+}
+
+func TestAssembliesConsumeBatches(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	l.registerBatch("filament", "FIL-9", 2)
+
+	serials := 0
+	create := func(filamentBatchId string) (string, error) {
+		serials++
+		var result *AssemblyCreateResult
+		err := l.invoke(func() (err error) {
+			result, err = l.tnt.CreateAssemblyFromJSON(ctx, `{"deviceSerialNo": "`+fmt.Sprintf("SN-%d", serials)+`", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created", "filamentBatchId": "`+filamentBatchId+`", "ledBatchId": "LED-1"}`)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return result.AssemblyId, nil
+	}
+	consumed := func(componentType string, batchId string) int {
+		batch, err := l.tnt.GetBatch(ctx, componentType, batchId)
+		if err != nil {
+			t.Fatalf("GetBatch: %s", err)
+		}
+		return batch.QuantityConsumed
+	}
+
+	_, err := create("FIL-NONE")
+	expectError(t, err, "Unknown filament batch FIL-NONE.")
+	if consumed("led", "LED-1") != 0 {
+		t.Errorf("failed create consumed a led")
+	}
+
+	first, err := create("FIL-9")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if _, err = create("FIL-9"); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	_, err = create("FIL-9")
+	expectError(t, err, "filament batch FIL-9 is exhausted: 2 of 2 used.")
+	if consumed("filament", "FIL-9") != 2 || consumed("led", "LED-1") != 2 {
+		t.Errorf("expected 2 filaments and 2 leds used, got %d and %d", consumed("filament", "FIL-9"), consumed("led", "LED-1"))
+	}
+
+	// Moving an assembly to another batch gives the component back
+	l.mustInvoke("PatchAssembly", func() error {
+		return l.tnt.PatchAssembly(ctx, first, `{"filamentBatchId": "FIL-1"}`)
+	})
+	if consumed("filament", "FIL-9") != 1 || consumed("filament", "FIL-1") != 1 {
+		t.Errorf("expected 1 filament used from each batch, got %d and %d", consumed("filament", "FIL-9"), consumed("filament", "FIL-1"))
+	}
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, first, `{"ledBatchId": "LED-NONE"}`)
+	})
+	expectError(t, err, "Unknown led batch LED-NONE.")
+}
@@ -0,0 +1,452 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// componentColumn is a component type that has its own batch column on
+// AssemblyLine. The columns predate the bill of materials and mirror the
+// Components of the assembly for the clients still reading them.
+type componentColumn struct {
+	Type   string
+	Column func(a *AssemblyLine) *string
+}
+
+// componentColumns are the component types every ledger knows. The bills of
+// materials add the others.
+var componentColumns = []componentColumn{
+	{"filament", func(a *AssemblyLine) *string { return &a.FilamentBatchId }},
+	{"led", func(a *AssemblyLine) *string { return &a.LedBatchId }},
+	{"circuitBoard", func(a *AssemblyLine) *string { return &a.CircuitBoardBatchId }},
+	{"wire", func(a *AssemblyLine) *string { return &a.WireBatchId }},
+	{"casing", func(a *AssemblyLine) *string { return &a.CasingBatchId }},
+	{"adaptor", func(a *AssemblyLine) *string { return &a.AdaptorBatchId }},
+	{"stickPod", func(a *AssemblyLine) *string { return &a.StickPodBatchId }},
+}
+
+// componentTypePattern is the format of the component type names
+var componentTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// findComponentType looks up a component type, ignoring case, and returns its
+// name as registered, empty when it is unknown, and the names of all of them
+func findComponentType(stub shim.ChaincodeStubInterface, componentType string) (string, []string, error) {
+	found := ""
+	names := []string{}
+	for _, c := range componentColumns {
+		if strings.EqualFold(c.Type, componentType) {
+			return c.Type, nil, nil
+		}
+		names = append(names, c.Type)
+	}
+
+	err := scanRecords(stub, keyComponentType, []string{}, func(keys []string, value []byte) error {
+		if strings.EqualFold(keys[0], componentType) {
+			found = keys[0]
+		}
+		names = append(names, keys[0])
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return found, names, nil
+}
+
+// getComponentType looks up a component type, ignoring case, and returns its
+// name as registered
+func getComponentType(stub shim.ChaincodeStubInterface, componentType string) (string, error) {
+	name, names, err := findComponentType(stub, componentType)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("Unknown component type %q. Expecting one of [%s].", componentType, strings.Join(names, ", "))
+	}
+	return name, nil
+}
+
+// BOMComponent is a line of a bill of materials
+type BOMComponent struct {
+	ComponentType string `json:"componentType"`
+	Quantity      int    `json:"quantity"`
+}
+
+// BillOfMaterials lists the components an assembly of a device type is built
+// with, and how many of each it takes from their batch
+type BillOfMaterials struct {
+	DeviceType string          `json:"deviceType"`
+	Components []*BOMComponent `json:"components"`
+	UpdatedBy  string          `json:"updatedBy"`
+	UpdatedOn  string          `json:"updatedOn"`
+}
+
+// quantityOf returns the quantity of a component type, 0 when it is not part
+// of the bill of materials
+func (bom *BillOfMaterials) quantityOf(componentType string) int {
+	for _, c := range bom.Components {
+		if c.ComponentType == componentType {
+			return c.Quantity
+		}
+	}
+	return 0
+}
+
+// getBillOfMaterials reads the bill of materials of a device type, nil when
+// it has none
+func getBillOfMaterials(stub shim.ChaincodeStubInterface, deviceType string) (*BillOfMaterials, error) {
+	bom := new(BillOfMaterials)
+	found, err := getRecord(stub, keyBOM, []string{deviceType}, bom)
+	if err != nil || !found {
+		return nil, err
+	}
+	return bom, nil
+}
+
+// prepareComponents sets the Components of an assembly being written, and the
+// quantities it takes from each batch, from those given by the caller and its
+// batch columns. previous is nil for a new assembly.
+func prepareComponents(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	components := map[string]string{}
+	seen := map[string]bool{}
+	for _, componentType := range sortedKeys(current.Components) {
+		name, err := getComponentType(stub, componentType)
+		if err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("Component %s is listed twice.", name)
+		}
+		seen[name] = true
+		if batchId := current.Components[componentType]; batchId != "" {
+			components[name] = batchId
+		}
+	}
+	current.Components = components
+
+	err := mergeComponentColumns(previous, current)
+	if err != nil {
+		return err
+	}
+	return checkBillOfMaterials(stub, previous, current)
+}
+
+// mergeComponentColumns folds the batch columns of an assembly into its
+// Components and mirrors the Components back into the columns. A column that
+// differs from the previous version was set by the caller and wins over
+// Components, unless Components names another new batch too.
+func mergeComponentColumns(previous *AssemblyLine, current *AssemblyLine) error {
+	for _, c := range componentColumns {
+		column := *c.Column(current)
+		previousColumn, previousBatch := "", ""
+		if previous != nil {
+			previousColumn = *c.Column(previous)
+			previousBatch = previous.Components[c.Type]
+		}
+		if column != previousColumn {
+			if batch := current.Components[c.Type]; batch != previousBatch && batch != column {
+				return fmt.Errorf("Conflicting %s batches %s and %s.", c.Type, column, batch)
+			}
+			if column == "" {
+				delete(current.Components, c.Type)
+			} else {
+				current.Components[c.Type] = column
+			}
+		}
+		*c.Column(current) = current.Components[c.Type]
+	}
+	return nil
+}
+
+// componentsChanged reports whether two assemblies are built differently
+func componentsChanged(previous *AssemblyLine, current *AssemblyLine) bool {
+	if previous == nil || previous.DeviceType != current.DeviceType || len(previous.Components) != len(current.Components) {
+		return true
+	}
+	for componentType, batchId := range current.Components {
+		if previous.Components[componentType] != batchId {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBillOfMaterials validates the components of an assembly against the
+// bill of materials of its device type and sets the quantities it takes from
+// each batch. Without a bill of materials the assembly may use any known
+// component type, one of each. It only checks assemblies whose device type or
+// components change, the others keep the quantities they were built with.
+func checkBillOfMaterials(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	if !componentsChanged(previous, current) {
+		current.ComponentQuantities = previous.ComponentQuantities
+		return nil
+	}
+
+	bom, err := getBillOfMaterials(stub, current.DeviceType)
+	if err != nil {
+		return err
+	}
+
+	quantities := map[string]int{}
+	for componentType := range current.Components {
+		quantities[componentType] = 1
+		if bom != nil {
+			quantities[componentType] = bom.quantityOf(componentType)
+			if quantities[componentType] == 0 {
+				return fmt.Errorf("Component %s is not in the bill of materials of device type %s.", componentType, current.DeviceType)
+			}
+		}
+	}
+	if bom != nil {
+		for _, c := range bom.Components {
+			if current.Components[c.ComponentType] == "" {
+				return fmt.Errorf("Missing the %s batch required by the bill of materials of device type %s.", c.ComponentType, current.DeviceType)
+			}
+		}
+	}
+
+	current.ComponentQuantities = quantities
+	return nil
+}
+
+// componentsOfColumns sets the Components of an assembly written before the
+// bill of materials from its batch columns, one component of each
+func componentsOfColumns(a *AssemblyLine) {
+	if a.Components != nil {
+		return
+	}
+	a.Components = map[string]string{}
+	a.ComponentQuantities = map[string]int{}
+	for _, c := range componentColumns {
+		if batchId := *c.Column(a); batchId != "" {
+			a.Components[c.Type] = batchId
+			a.ComponentQuantities[c.Type] = 1
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// indexComponentBatches moves the entries of an assembly in the index of the
+// assemblies by component batch from its previous components to the current
+// ones. The entries are keyed by component type, batch id and assemblyId.
+func indexComponentBatches(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	if previous != nil {
+		for _, componentType := range sortedKeys(previous.Components) {
+			batchId := previous.Components[componentType]
+			if current.Components[componentType] == batchId {
+				continue
+			}
+			err := deleteRecord(stub, keyAssemblyByBatch, []string{componentType, batchId, current.AssemblyId})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, componentType := range sortedKeys(current.Components) {
+		batchId := current.Components[componentType]
+		if previous != nil && previous.Components[componentType] == batchId {
+			continue
+		}
+		err := putIndex(stub, keyAssemblyByBatch, componentType, batchId, current.AssemblyId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAssemblyIdsByBatch returns the ids of the assemblies built with a batch
+func getAssemblyIdsByBatch(stub shim.ChaincodeStubInterface, componentType string, batchId string) ([]string, error) {
+	assemblyIds := []string{}
+	err := scanRecords(stub, keyAssemblyByBatch, []string{componentType, batchId}, func(keys []string, value []byte) error {
+		assemblyIds = append(assemblyIds, keys[2])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assemblyIds, nil
+}
+
+// columnIndexes are the names of the indexes of the assemblies by the batch
+// of each component column, replaced by the index of keyAssemblyByBatch
+var columnIndexes = []string{
+	"assembly~filamentBatch", "assembly~ledBatch", "assembly~circuitBoardBatch", "assembly~wireBatch",
+	"assembly~casingBatch", "assembly~adaptorBatch", "assembly~stickPodBatch",
+}
+
+// migrateComponents moves the batch columns of the existing assemblies into
+// their Components and reindexes them by component batch
+func migrateComponents(stub shim.ChaincodeStubInterface) error {
+	err := scanRecords(stub, keyAssembly, []string{}, func(keys []string, value []byte) error {
+		assembly, err := decodeAssembly(value)
+		if err != nil {
+			return err
+		}
+		if assembly.Components != nil {
+			return nil
+		}
+		componentsOfColumns(assembly)
+		err = putRecord(stub, keyAssembly, keys, assembly)
+		if err != nil {
+			return err
+		}
+		return indexComponentBatches(stub, nil, assembly)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, index := range columnIndexes {
+		err = scanRecords(stub, index, []string{}, func(keys []string, value []byte) error {
+			return deleteRecord(stub, index, keys)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Admin API to set the bill of materials of a device type. components is a
+//JSON object of the component types and the quantity of each an assembly
+//takes, e.g. {"led": 2, "casing": 1}. New component types are registered.
+func (t *TnT) SetBillOfMaterials(ctx contractapi.TransactionContextInterface, deviceType string, components string) error {
+	stub := ctx.GetStub()
+
+	if deviceType == "" {
+		return errors.New("Device type must not be empty.")
+	}
+	_deviceType, err := getDeviceType(stub, deviceType)
+	if err != nil {
+		return err
+	}
+	deviceType = _deviceType.Code
+	quantities := map[string]int{}
+	err = json.Unmarshal([]byte(components), &quantities)
+	if err != nil {
+		return fmt.Errorf("Invalid components %s. Expecting a JSON object of component types and quantities.", components)
+	}
+	if len(quantities) == 0 {
+		return errors.New("A bill of materials needs at least one component.")
+	}
+
+	bom := &BillOfMaterials{DeviceType: deviceType, Components: []*BOMComponent{}}
+	names := []string{}
+	for componentType := range quantities {
+		names = append(names, componentType)
+	}
+	// Report the same problem first whatever the order of the object
+	sort.Strings(names)
+	for _, componentType := range names {
+		if quantities[componentType] < 1 {
+			return fmt.Errorf("Invalid quantity %d of component %s. Expecting at least 1.", quantities[componentType], componentType)
+		}
+		name, _, err := findComponentType(stub, componentType)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			// A new component type
+			if !componentTypePattern.MatchString(componentType) {
+				return fmt.Errorf("Invalid component type %q. Expecting letters and digits.", componentType)
+			}
+			name = componentType
+			err = putIndex(stub, keyComponentType, name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, c := range bom.Components {
+			if strings.EqualFold(c.ComponentType, name) {
+				return fmt.Errorf("Component %s is listed twice.", name)
+			}
+		}
+		bom.Components = append(bom.Components, &BOMComponent{ComponentType: name, Quantity: quantities[componentType]})
+	}
+
+	sort.Slice(bom.Components, func(i, j int) bool {
+		return bom.Components[i].ComponentType < bom.Components[j].ComponentType
+	})
+
+	bom.UpdatedBy, err = callerName(ctx)
+	if err != nil {
+		return err
+	}
+	bom.UpdatedOn, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, keyBOM, []string{deviceType}, bom)
+}
+
+//get the bill of materials of a device type, ignoring case
+func (t *TnT) GetBillOfMaterials(ctx contractapi.TransactionContextInterface, deviceType string) (*BillOfMaterials, error) {
+	stub := ctx.GetStub()
+
+	_deviceType, err := getDeviceType(stub, deviceType)
+	if err != nil {
+		return nil, err
+	}
+	bom, err := getBillOfMaterials(stub, _deviceType.Code)
+	if err != nil {
+		return nil, err
+	}
+	if bom == nil {
+		return nil, fmt.Errorf("No bill of materials for device type %s.", _deviceType.Code)
+	}
+	return bom, nil
+}
+
+//get every bill of materials
+func (t *TnT) GetAllBillOfMaterials(ctx contractapi.TransactionContextInterface) ([]*BillOfMaterials, error) {
+	boms := []*BillOfMaterials{}
+	err := scanRecords(ctx.GetStub(), keyBOM, []string{}, func(keys []string, value []byte) error {
+		bom := new(BillOfMaterials)
+		err := json.Unmarshal(value, bom)
+		if err != nil {
+			return fmt.Errorf("Corrupt bill of materials of %s: %s", keys[0], err)
+		}
+		boms = append(boms, bom)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return boms, nil
+}
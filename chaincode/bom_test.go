@@ -0,0 +1,253 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestSetBillOfMaterials(t *testing.T) {
+	tests := []struct {
+		name       string
+		deviceType string
+		components string
+		wantErr    string
+		want       string
+	}{
+		{"new component type", "Holder", `{"LED": 2, "filament": 1, "glass": 1}`, "", "filament:1 glass:1 led:2"},
+		{"replaced", "Holder", `{"led": 1, "Glass": 3}`, "", "glass:3 led:1"},
+		{"no device type", "", `{"led": 1}`, "Device type must not be empty.", ""},
+		{"unknown device type", "Toaster", `{"led": 1}`, `Unknown device type "Toaster". Expecting one of [Charger, Holder].`, ""},
+		{"not an object", "Holder", `["led"]`, `Invalid components ["led"]. Expecting a JSON object of component types and quantities.`, ""},
+		{"empty", "Holder", `{}`, "A bill of materials needs at least one component.", ""},
+		{"no quantity", "Holder", `{"led": 0}`, "Invalid quantity 0 of component led. Expecting at least 1.", ""},
+		{"bad name", "Holder", `{"glass pane": 1}`, `Invalid component type "glass pane". Expecting letters and digits.`, ""},
+		{"listed twice", "Holder", `{"led": 1, "Led": 1}`, "Component led is listed twice.", ""},
+		{"new type listed twice", "Charger", `{"cable": 1, "Cable": 1}`, "Component cable is listed twice.", ""},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				return l.tnt.SetBillOfMaterials(l.admin(), test.deviceType, test.components)
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetBillOfMaterials: %s", err)
+			}
+
+			bom, err := l.tnt.GetBillOfMaterials(l.admin(), test.deviceType)
+			if err != nil {
+				t.Fatalf("GetBillOfMaterials: %s", err)
+			}
+			got := ""
+			for _, c := range bom.Components {
+				got += fmt.Sprintf(" %s:%d", c.ComponentType, c.Quantity)
+			}
+			if got[1:] != test.want || bom.UpdatedBy != "admin1@PlantMSP" {
+				t.Errorf("expected components %s, got %s", test.want, got[1:])
+			}
+		})
+	}
+
+	// The new component type can be used like the others
+	l.registerBatch("GLASS", "GL-1", 10)
+	if _, err := l.tnt.GetBatch(l.admin(), "glass", "GL-1"); err != nil {
+		t.Errorf("GetBatch: %s", err)
+	}
+	err := l.invoke(func() error {
+		return l.tnt.RegisterBatch(l.admin(), "cable", "CA-1", "ACME", "2024-01-01", 1, testCertificateHash)
+	})
+	expectError(t, err, `Unknown component type "cable". Expecting one of [filament, led, circuitBoard, wire, casing, adaptor, stickPod, glass].`)
+
+	boms, err := l.tnt.GetAllBillOfMaterials(l.admin())
+	if err != nil || len(boms) != 1 {
+		t.Errorf("expected 1 bill of materials, got %d: %v", len(boms), err)
+	}
+	_, err = l.tnt.GetBillOfMaterials(l.admin(), "charger")
+	expectError(t, err, "No bill of materials for device type Charger.")
+	_, err = l.tnt.GetBillOfMaterials(l.admin(), "Toaster")
+	expectError(t, err, `Unknown device type "Toaster". Expecting one of [Charger, Holder].`)
+	if bom, err := l.tnt.GetBillOfMaterials(l.admin(), "HOLDER"); err != nil || bom.DeviceType != DeviceTypeHolder {
+		t.Errorf("expected the Holder bill of materials, got %+v: %v", bom, err)
+	}
+}
+
+func TestAssemblyBillOfMaterials(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	l.mustInvoke("SetBillOfMaterials", func() error {
+		return l.tnt.SetBillOfMaterials(l.admin(), DeviceTypeHolder, `{"filament": 1, "led": 2, "glass": 1}`)
+	})
+	l.registerBatch("glass", "GL-1", 10)
+
+	create := func(fields string) (string, error) {
+		var result *AssemblyCreateResult
+		err := l.invoke(func() (err error) {
+			result, err = l.tnt.CreateAssemblyFromJSON(ctx, `{"deviceSerialNo": "SN", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created", `+fields+`}`)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return result.AssemblyId, nil
+	}
+	consumed := func(componentType string, batchId string) int {
+		batch, err := l.tnt.GetBatch(ctx, componentType, batchId)
+		if err != nil {
+			t.Fatalf("GetBatch: %s", err)
+		}
+		return batch.QuantityConsumed
+	}
+
+	tests := []struct {
+		name    string
+		fields  string
+		wantErr string
+	}{
+		{"missing", `"components": {"filament": "FIL-1", "led": "LED-1"}`, "Missing the glass batch required by the bill of materials of device type Holder."},
+		{"not in BOM", `"components": {"filament": "FIL-1", "led": "LED-1", "glass": "GL-1", "wire": "WIRE-1"}`, "Component wire is not in the bill of materials of device type Holder."},
+		{"unknown type", `"components": {"filament": "FIL-1", "led": "LED-1", "glass": "GL-1", "cable": "CA-1"}`, `Unknown component type "cable". Expecting one of [filament, led, circuitBoard, wire, casing, adaptor, stickPod, glass].`},
+		{"listed twice", `"components": {"filament": "FIL-1", "led": "LED-1", "LED": "LED-2", "glass": "GL-1"}`, "Component led is listed twice."},
+		{"conflicting column", `"components": {"filament": "FIL-1", "led": "LED-1", "glass": "GL-1"}, "ledBatchId": "LED-2"`, "Conflicting led batches LED-2 and LED-1."},
+		{"not strings", `"components": {"led": 1}`, "Invalid assembly: components must be an object of strings."},
+		{"unknown batch", `"components": {"filament": "FIL-1", "led": "LED-1", "glass": "GL-9"}`, "Unknown glass batch GL-9."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := create(test.fields)
+			expectError(t, err, test.wantErr)
+		})
+	}
+
+	// The batch columns and the components are two views of the same batches
+	assemblyId, err := create(`"components": {"Glass": "GL-1", "led": "LED-1"}, "filamentBatchId": "FIL-1"`)
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	assembly := l.assembly(assemblyId)
+	if fmt.Sprint(assembly.Components) != "map[filament:FIL-1 glass:GL-1 led:LED-1]" || assembly.FilamentBatchId != "FIL-1" || assembly.LedBatchId != "LED-1" || assembly.WireBatchId != "" {
+		t.Errorf("unexpected components %+v", assembly)
+	}
+	if consumed("led", "LED-1") != 2 || consumed("glass", "GL-1") != 1 {
+		t.Errorf("expected 2 leds and 1 glass used, got %d and %d", consumed("led", "LED-1"), consumed("glass", "GL-1"))
+	}
+
+	// A merge patch of the components changes one batch
+	l.mustInvoke("PatchAssembly", func() error {
+		return l.tnt.PatchAssembly(ctx, assemblyId, `{"components": {"led": "LED-2"}}`)
+	})
+	assembly = l.assembly(assemblyId)
+	if assembly.LedBatchId != "LED-2" || assembly.Components["glass"] != "GL-1" {
+		t.Errorf("unexpected components %+v", assembly)
+	}
+	if consumed("led", "LED-1") != 0 || consumed("led", "LED-2") != 2 {
+		t.Errorf("expected the leds moved to LED-2, got %d and %d", consumed("led", "LED-1"), consumed("led", "LED-2"))
+	}
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, assemblyId, `{"components": {"glass": null}}`)
+	})
+	expectError(t, err, "Missing the glass batch required by the bill of materials of device type Holder.")
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, assemblyId, `{"componentQuantities": {"led": 1}}`)
+	})
+	expectError(t, err, "Invalid assembly patch: componentQuantities cannot be changed.")
+
+	// A positional update changes the batch columns and keeps the components
+	// without a column
+	l.mustInvoke("UpdateAssemblyByID", func() error {
+		return l.tnt.UpdateAssemblyByID(ctx, assemblyId, "SN", DeviceTypeHolder, "FIL-2", "LED-2", "", "", "", "", "", "PLANT1", AssemblyCreated, "")
+	})
+	assembly = l.assembly(assemblyId)
+	if fmt.Sprint(assembly.Components) != "map[filament:FIL-2 glass:GL-1 led:LED-2]" || assembly.FilamentBatchId != "FIL-2" {
+		t.Errorf("unexpected components %+v", assembly)
+	}
+
+	// A new bill of materials applies to the assemblies built from then on
+	l.mustInvoke("SetBillOfMaterials", func() error {
+		return l.tnt.SetBillOfMaterials(l.admin(), DeviceTypeHolder, `{"led": 1}`)
+	})
+	l.moveAssembly(ctx, assemblyId, AssemblyInAssembly)
+
+	// Updating the components with the new bill of materials gives the
+	// components taken with the old one back
+	l.mustInvoke("UpdateAssemblyFromJSON", func() error {
+		return l.tnt.UpdateAssemblyFromJSON(ctx, `{"assemblyId": "`+assemblyId+`", "deviceSerialNo": "SN", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "InAssembly", "components": {"led": "LED-2"}}`)
+	})
+	assembly = l.assembly(assemblyId)
+	if fmt.Sprint(assembly.Components) != "map[led:LED-2]" || assembly.FilamentBatchId != "" || assembly.ComponentQuantities["led"] != 1 {
+		t.Errorf("unexpected components %+v", assembly)
+	}
+	if consumed("led", "LED-2") != 1 || consumed("filament", "FIL-1") != 0 || consumed("glass", "GL-1") != 0 {
+		t.Errorf("expected 1 led used and the others given back, got %d, %d and %d", consumed("led", "LED-2"), consumed("filament", "FIL-1"), consumed("glass", "GL-1"))
+	}
+
+	impact, err := l.tnt.GetAffectedByBatch(ctx, "led", "LED-2")
+	if err != nil {
+		t.Fatalf("GetAffectedByBatch: %s", err)
+	}
+	if len(impact.Assemblies) != 1 || impact.Assemblies[0].AssemblyId != assemblyId {
+		t.Errorf("expected assembly %s built with LED-2, got %+v", assemblyId, impact.Assemblies)
+	}
+	if ids, _ := getAssemblyIdsByBatch(l.stub, "glass", "GL-1"); len(ids) != 0 {
+		t.Errorf("assembly still indexed under GL-1: %v", ids)
+	}
+}
+
+func TestMigrateComponents(t *testing.T) {
+	l := newTestLedger(t)
+
+	// An assembly written before the bill of materials, with its batch
+	// column index entry
+	legacy := map[string]string{"assemblyId": "ASM-OLD", "deviceType": "Holder", "filamentBatchId": "FIL-1", "ledBatchId": "LED-1", "assemblyStatus": "Created"}
+	value, _ := json.Marshal(legacy)
+	l.mustInvoke("write legacy assembly", func() error {
+		key, _ := l.stub.CreateCompositeKey(keyAssembly, []string{"ASM-OLD"})
+		if err := l.stub.PutState(key, value); err != nil {
+			return err
+		}
+		return putIndex(l.stub, "assembly~filamentBatch", "FIL-1", "ASM-OLD")
+	})
+
+	l.mustInvoke("migrateComponents", func() error { return migrateComponents(l.stub) })
+
+	assembly := l.assembly("ASM-OLD")
+	if fmt.Sprint(assembly.Components) != "map[filament:FIL-1 led:LED-1]" || fmt.Sprint(assembly.ComponentQuantities) != "map[filament:1 led:1]" {
+		t.Errorf("unexpected components %+v", assembly)
+	}
+	if ids, _ := getAssemblyIdsByBatch(l.stub, "led", "LED-1"); fmt.Sprint(ids) != "[ASM-OLD]" {
+		t.Errorf("expected ASM-OLD indexed under LED-1, got %v", ids)
+	}
+	if ids, _ := getAssemblyIdsByIndex(l.stub, assemblyIndex{Name: "assembly~filamentBatch"}, "FIL-1"); len(ids) != 0 {
+		t.Errorf("batch column index not dropped: %v", ids)
+	}
+
+	// Running it again changes nothing
+	l.mustInvoke("migrateComponents", func() error { return migrateComponents(l.stub) })
+	if ids, _ := getAssemblyIdsByBatch(l.stub, "led", "LED-1"); fmt.Sprint(ids) != "[ASM-OLD]" {
+		t.Errorf("expected ASM-OLD indexed under LED-1, got %v", ids)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// transactionParams are the parameters every transaction function takes after
+// its context. The contract API rejects invocations with another argument
+// count before the function runs, so these counts are what clients rely on.
+var transactionParams = map[string]int{
+	"CreateAssembly":             12,
+	"CreateAssemblyFromJSON":     1,
+	"UpdateAssemblyByID":         13,
+	"UpdateAssemblyFromJSON":     1,
+	"PatchAssembly":              2,
+	"CreatePackage":              6,
+	"CreatePackageFromJSON":      1,
+	"UpdatePackageByCaseID":      7,
+	"UpdatePackageFromJSON":      1,
+	"PatchPackage":               2,
+	"TransitionPackage":          4,
+	"RegisterBatch":              6,
+	"RegisterSupplier":           5,
+	"SetSupplierStatus":          2,
+	"SubmitBatchAttestation":     2,
+	"SetBillOfMaterials":         2,
+	"SetDeviceType":              5,
+	"RemoveDeviceType":           1,
+	"OpenRecall":                 3,
+	"CloseRecall":                2,
+	"AddStatusTransition":        3,
+	"RemoveStatusTransition":     3,
+	"GrantRole":                  2,
+	"RevokeRole":                 2,
+	"AddOperatorMSP":             1,
+	"RemoveOperatorMSP":          1,
+	"ImportLegacyRows":           1,
+	"InitLedger":                 1,
+	"GetAllAssembly":             0,
+	"GetAllAssemblyPage":         2,
+	"GetAssemblyByID":            1,
+	"GetAllAssemblyByStatus":     1,
+	"GetAllAssemblyByStatusPage": 3,
+	"GetAllAssemblyByPlant":      1,
+	"GetAssemblyHistory":         1,
+	"GetAllPackage":              0,
+	"GetAllPackagePage":          2,
+	"GetPackageByID":             1,
+	"GetPackageHistory":          1,
+	"GetPackageByAssemblyID":     1,
+	"GetPackageMilestones":       1,
+	"GetAffectedByBatch":         2,
+	"GetRecallStatus":            1,
+	"GetBatch":                   2,
+	"GetSupplier":                1,
+	"GetBillOfMaterials":         1,
+	"GetAllBillOfMaterials":      0,
+	"GetDeviceType":              1,
+	"GetAssemblyBySerialNo":      1,
+	"GetAllDeviceTypes":          0,
+	"GetStatusTransitions":       1,
+	"GetAccessPolicy":            1,
+	"GetOperatorMSPs":            0,
+	"GetSchemaVersion":           0,
+}
+
+func TestTransactionSignatures(t *testing.T) {
+	ctxType := reflect.TypeOf((*contractapi.TransactionContextInterface)(nil)).Elem()
+	tntType := reflect.TypeOf(new(TnT))
+
+	found := map[string]bool{}
+	for i := 0; i < tntType.NumMethod(); i++ {
+		method := tntType.Method(i)
+		if method.Type.NumIn() < 2 || method.Type.In(1) != ctxType {
+			continue
+		}
+		found[method.Name] = true
+
+		params, ok := transactionParams[method.Name]
+		if !ok {
+			t.Errorf("transaction %s is not covered by transactionParams", method.Name)
+			continue
+		}
+		if got := method.Type.NumIn() - 2; got != params {
+			t.Errorf("%s takes %d parameters, clients send %d", method.Name, got, params)
+		}
+	}
+
+	for name := range transactionParams {
+		if !found[name] {
+			t.Errorf("transaction %s is missing", name)
+		}
+	}
+}
+
+// jsonKeys returns the sorted keys of the JSON object value marshals to
+func jsonKeys(t *testing.T, value interface{}) []string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Marshal %T: %s", value, err)
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		t.Fatalf("%T is not a JSON object: %s", value, err)
+	}
+
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestJSONShapes(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		keys  string
+	}{
+		{AssemblyLine{}, "adaptorBatchId assemblyCreatedBy assemblyCreationDate assemblyId assemblyLastUpdateOn assemblyLastUpdatedBy assemblyStatus caseId casingBatchId circuitBoardBatchId componentQuantities components deviceSerialNo deviceType filamentBatchId ledBatchId manufacturingPlant stickPodBatchId wireBatchId"},
+		{PackageLine{}, "caseId chargerAssemblyId holderAssemblyId packageCreatedBy packageLastUpdateOn packageLastUpdatedBy packageStatus packagingCreationDate packagingDate shippingToAddress"},
+		{AssemblyCreateResult{}, "assemblyId"},
+		{PackageCreateResult{}, "caseId"},
+		{RecallOpenResult{}, "recallId"},
+		{AssemblyPage{}, "count items nextBookmark"},
+		{PackagePage{}, "count items nextBookmark"},
+		{AssemblyHistoryEntry{}, "changes record txId updatedBy updatedOn version"},
+		{PackageHistoryEntry{}, "changes record txId updatedBy updatedOn version"},
+		{FieldChange{}, "field from to"},
+		{PackageMilestone{}, "caseId location note recordedBy recordedOn sequence status txId"},
+		{StatusTransition{}, "fromStatus objectType toStatus"},
+		{Grant{}, "function role"},
+		{BatchImpact{}, "assemblies batchId cases componentType"},
+		{AffectedCase{}, "assemblyIds caseId packageStatus shippingToAddress"},
+		{Recall{}, "assemblyIds batchIds closedBy closedOn componentType openedBy openedOn reason recallId resolution status"},
+		{RecallStatus{}, "assemblies cases recall"},
+		{SchemaVersion{}, "applied version"},
+		{MigrationRun{}, "appliedOn description txId version"},
+		{LegacyTableImport{}, "imported skipped table"},
+		{FieldError{}, "field problem"},
+		{ComponentBatch{}, "attestation batchId certificateHash componentType quantityConsumed quantityReceived receivedDate registeredBy registeredOn signature supplier supplierId"},
+		{Supplier{}, "componentTypes mspId name publicKey registeredBy registeredOn status supplierId updatedBy updatedOn"},
+		{BillOfMaterials{}, "components deviceType updatedBy updatedOn"},
+		{BOMComponent{}, "componentType quantity"},
+		{DeviceType{}, "allowedPlants code description packagingRole productFamily updatedBy updatedOn"},
+		{BatchAttestation{}, "batchId certificateHash componentType quantity shippedDate supplierId"},
+	}
+
+	for _, test := range tests {
+		keys := strings.Join(jsonKeys(t, test.value), " ")
+		if keys != test.keys {
+			t.Errorf("%T marshals to keys [%s], expected [%s]", test.value, keys, test.keys)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Packaging roles of the device types: a case packs one holder and one charger
+const (
+	PackagingRoleHolder  = "holder"
+	PackagingRoleCharger = "charger"
+)
+
+// DeviceType is an entry of the device type catalog. Assemblies can only be
+// built of the device types in the catalog.
+type DeviceType struct {
+	Code          string `json:"code"`
+	Description   string `json:"description"`
+	ProductFamily string `json:"productFamily"`
+	// AllowedPlants are the plants building the device type, all of them when
+	// empty
+	AllowedPlants []string `json:"allowedPlants"`
+	PackagingRole string   `json:"packagingRole"`
+	UpdatedBy     string   `json:"updatedBy"`
+	UpdatedOn     string   `json:"updatedOn"`
+}
+
+// defaultDeviceTypes are seeded in the catalog by the migrations
+var defaultDeviceTypes = []DeviceType{
+	{Code: DeviceTypeHolder, Description: "Holder", PackagingRole: PackagingRoleHolder, AllowedPlants: []string{}},
+	{Code: DeviceTypeCharger, Description: "Charger", PackagingRole: PackagingRoleCharger, AllowedPlants: []string{}},
+}
+
+// seedDeviceTypes stores the default device types, keeping those already
+// edited by the admins
+func seedDeviceTypes(stub shim.ChaincodeStubInterface) error {
+	for _, deviceType := range defaultDeviceTypes {
+		deviceType := deviceType
+		_, err := insertRecord(stub, keyDeviceType, []string{deviceType.Code}, &deviceType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getDeviceTypes returns the device type catalog, in code order
+func getDeviceTypes(stub shim.ChaincodeStubInterface) ([]*DeviceType, error) {
+	deviceTypes := []*DeviceType{}
+	err := scanRecords(stub, keyDeviceType, []string{}, func(keys []string, value []byte) error {
+		deviceType := new(DeviceType)
+		err := json.Unmarshal(value, deviceType)
+		if err != nil {
+			return fmt.Errorf("Corrupt device type %s: %s", keys[0], err)
+		}
+		deviceTypes = append(deviceTypes, deviceType)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deviceTypes, nil
+}
+
+// findDeviceType looks up a device type of the catalog, ignoring case. It
+// returns nil when it is unknown, and the codes of the catalog.
+func findDeviceType(stub shim.ChaincodeStubInterface, code string) (*DeviceType, []string, error) {
+	deviceTypes, err := getDeviceTypes(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+	codes := []string{}
+	for _, deviceType := range deviceTypes {
+		if strings.EqualFold(deviceType.Code, code) {
+			return deviceType, nil, nil
+		}
+		codes = append(codes, deviceType.Code)
+	}
+	return nil, codes, nil
+}
+
+// getDeviceType looks up a device type of the catalog, ignoring case
+func getDeviceType(stub shim.ChaincodeStubInterface, code string) (*DeviceType, error) {
+	deviceType, codes, err := findDeviceType(stub, code)
+	if err != nil {
+		return nil, err
+	}
+	if deviceType == nil {
+		return nil, fmt.Errorf("Unknown device type %q. Expecting one of [%s].", code, strings.Join(codes, ", "))
+	}
+	return deviceType, nil
+}
+
+// checkDeviceType checks that the device type of an assembly is in the catalog
+// and built at its plant, and sets it to its code in the catalog. Assemblies
+// keep their device type and plant without being checked again, so those
+// built before a catalog change can still move through their lifecycle.
+func checkDeviceType(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	if previous != nil && previous.DeviceType == current.DeviceType && previous.ManufacturingPlant == current.ManufacturingPlant {
+		return nil
+	}
+
+	deviceType, err := getDeviceType(stub, current.DeviceType)
+	if err != nil {
+		return err
+	}
+	if len(deviceType.AllowedPlants) > 0 && !stringsContain(deviceType.AllowedPlants, current.ManufacturingPlant) {
+		return fmt.Errorf("Device type %s is not built at plant %s.", deviceType.Code, current.ManufacturingPlant)
+	}
+	current.DeviceType = deviceType.Code
+	return nil
+}
+
+//Admin API to add a device type to the catalog or change it. allowedPlants
+//restricts the plants building it, all of them when empty. The packaging role
+//is holder or charger.
+func (t *TnT) SetDeviceType(ctx contractapi.TransactionContextInterface, code string, description string, productFamily string, allowedPlants []string, packagingRole string) error {
+	stub := ctx.GetStub()
+
+	if strings.TrimSpace(code) == "" {
+		return errors.New("Device type code must not be empty.")
+	}
+	if packagingRole != PackagingRoleHolder && packagingRole != PackagingRoleCharger {
+		return fmt.Errorf("Invalid packaging role %q. Expecting %s or %s.", packagingRole, PackagingRoleHolder, PackagingRoleCharger)
+	}
+	plants := []string{}
+	for _, plant := range allowedPlants {
+		if plant == "" {
+			return errors.New("Allowed plants must not be empty.")
+		}
+		if !stringsContain(plants, plant) {
+			plants = append(plants, plant)
+		}
+	}
+
+	// Codes differing only in case would name the same device type
+	existing, _, err := findDeviceType(stub, code)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Code != code {
+		return fmt.Errorf("Device type %s already exists.", existing.Code)
+	}
+
+	deviceType := &DeviceType{
+		Code:          code,
+		Description:   description,
+		ProductFamily: productFamily,
+		AllowedPlants: plants,
+		PackagingRole: packagingRole,
+	}
+	deviceType.UpdatedBy, err = callerName(ctx)
+	if err != nil {
+		return err
+	}
+	deviceType.UpdatedOn, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, keyDeviceType, []string{code}, deviceType)
+}
+
+//Admin API to remove a device type from the catalog, ignoring case. Packing
+//needs the device type in the catalog, so it cannot be removed while
+//assemblies of it are yet to be packed. Those already packed are kept, and
+//no new ones can be built.
+func (t *TnT) RemoveDeviceType(ctx contractapi.TransactionContextInterface, code string) error {
+	stub := ctx.GetStub()
+
+	deviceType, _, err := findDeviceType(stub, code)
+	if err != nil {
+		return err
+	}
+	if deviceType == nil {
+		return fmt.Errorf("Device type %s not found.", code)
+	}
+
+	transitions, err := getTransitions(stub, objectAssembly, "")
+	if err != nil {
+		return err
+	}
+	packable := map[string]bool{}
+	err = scanRecords(stub, keyAssembly, []string{}, func(keys []string, value []byte) error {
+		assembly, err := decodeAssembly(value)
+		if err != nil {
+			return err
+		}
+		if assembly.CaseId != "" || !strings.EqualFold(assembly.DeviceType, deviceType.Code) {
+			return nil
+		}
+		status := assembly.AssemblyStatus
+		if _, ok := packable[status]; !ok {
+			packable[status] = reachableStatuses(transitions, status, "")[AssemblyPackaged]
+		}
+		if packable[status] {
+			return fmt.Errorf("Device type %s cannot be removed while assembly %s of it is yet to be packed.", deviceType.Code, assembly.AssemblyId)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteRecord(stub, keyDeviceType, []string{deviceType.Code})
+}
+
+//get a DeviceType of the catalog
+func (t *TnT) GetDeviceType(ctx contractapi.TransactionContextInterface, code string) (*DeviceType, error) {
+	return getDeviceType(ctx.GetStub(), code)
+}
+
+//get the device type catalog
+func (t *TnT) GetAllDeviceTypes(ctx contractapi.TransactionContextInterface) ([]*DeviceType, error) {
+	return getDeviceTypes(ctx.GetStub())
+}
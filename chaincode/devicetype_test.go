@@ -0,0 +1,159 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSetDeviceType(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		plants  []string
+		role    string
+		wantErr string
+	}{
+		{"added", "MiniHolder", []string{"PLANT2", "PLANT2"}, PackagingRoleHolder, ""},
+		{"changed", "MiniHolder", nil, PackagingRoleHolder, ""},
+		{"no code", " ", nil, PackagingRoleHolder, "Device type code must not be empty."},
+		{"bad role", "Cable", nil, "cable", `Invalid packaging role "cable". Expecting holder or charger.`},
+		{"empty plant", "Cable", []string{""}, PackagingRoleCharger, "Allowed plants must not be empty."},
+		{"same code in another case", "HOLDER", nil, PackagingRoleHolder, "Device type Holder already exists."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				return l.tnt.SetDeviceType(l.admin(), test.code, "Mini holder", "Mini", test.plants, test.role)
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetDeviceType: %s", err)
+			}
+			deviceType, err := l.tnt.GetDeviceType(l.admin(), "miniholder")
+			if err != nil {
+				t.Fatalf("GetDeviceType: %s", err)
+			}
+			if deviceType.Code != test.code || deviceType.ProductFamily != "Mini" || len(deviceType.AllowedPlants) > 1 || deviceType.UpdatedBy != "admin1@PlantMSP" {
+				t.Errorf("unexpected device type %+v", deviceType)
+			}
+		})
+	}
+
+	deviceTypes, err := l.tnt.GetAllDeviceTypes(l.admin())
+	if err != nil {
+		t.Fatalf("GetAllDeviceTypes: %s", err)
+	}
+	codes := []string{}
+	for _, deviceType := range deviceTypes {
+		codes = append(codes, deviceType.Code+":"+deviceType.PackagingRole)
+	}
+	if fmt.Sprint(codes) != "[Charger:charger Holder:holder MiniHolder:holder]" {
+		t.Errorf("unexpected catalog %v", codes)
+	}
+
+	l.mustInvoke("RemoveDeviceType", func() error { return l.tnt.RemoveDeviceType(l.admin(), "miniholder") })
+	_, err = l.tnt.GetDeviceType(l.admin(), "MiniHolder")
+	expectError(t, err, `Unknown device type "MiniHolder". Expecting one of [Charger, Holder].`)
+	err = l.invoke(func() error { return l.tnt.RemoveDeviceType(l.admin(), "MiniHolder") })
+	expectError(t, err, "Device type MiniHolder not found.")
+}
+
+func TestAssemblyDeviceType(t *testing.T) {
+	l := newTestLedger(t)
+	admin := l.admin()
+	l.mustInvoke("SetDeviceType", func() error {
+		return l.tnt.SetDeviceType(admin, "MiniHolder", "Mini holder", "Mini", []string{"PLANT2"}, PackagingRoleHolder)
+	})
+
+	create := func(deviceType string, plant string) (string, error) {
+		var result *AssemblyCreateResult
+		err := l.invoke(func() (err error) {
+			result, err = l.tnt.CreateAssemblyFromJSON(admin, `{"deviceSerialNo": "SN", "deviceType": "`+deviceType+`", "manufacturingPlant": "`+plant+`", "assemblyStatus": "Created"}`)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return result.AssemblyId, nil
+	}
+
+	_, err := create("Toaster", "PLANT1")
+	expectError(t, err, `Unknown device type "Toaster". Expecting one of [Charger, Holder, MiniHolder].`)
+	_, err = create("MiniHolder", "PLANT1")
+	expectError(t, err, "Device type MiniHolder is not built at plant PLANT1.")
+
+	assemblyId, err := create("miniholder", "PLANT2")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if deviceType := l.assembly(assemblyId).DeviceType; deviceType != "MiniHolder" {
+		t.Errorf("expected the catalog code MiniHolder, got %s", deviceType)
+	}
+
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(admin, assemblyId, `{"manufacturingPlant": "PLANT1"}`)
+	})
+	expectError(t, err, "Device type MiniHolder is not built at plant PLANT1.")
+
+	// Catalog changes do not strand the assemblies built of the device type
+	err = l.invoke(func() error { return l.tnt.RemoveDeviceType(admin, "miniholder") })
+	expectError(t, err, "Device type MiniHolder cannot be removed while assembly "+assemblyId+" of it is yet to be packed.")
+	l.mustInvoke("SetDeviceType", func() error {
+		return l.tnt.SetDeviceType(admin, "MiniHolder", "Mini holder", "Mini", []string{"PLANT1"}, PackagingRoleHolder)
+	})
+	l.moveAssembly(admin, assemblyId, AssemblyInAssembly)
+}
+
+func TestPackagingRoles(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RolePacker+","+RoleAssembler)
+	l.mustInvoke("SetDeviceType", func() error {
+		return l.tnt.SetDeviceType(l.admin(), "MiniHolder", "Mini holder", "Mini", nil, PackagingRoleHolder)
+	})
+
+	// Any device type packed as a holder can go with a charger
+	holderId := l.packableAssembly(packer, "SN-M1", "MiniHolder", "PLANT1")
+	chargerId := l.packableAssembly(packer, "SN-C1", DeviceTypeCharger, "PLANT1")
+	otherHolderId := l.packableAssembly(packer, "SN-H1", DeviceTypeHolder, "PLANT1")
+
+	pack := func(holderId string, chargerId string) error {
+		return l.invoke(func() error {
+			_, err := l.tnt.CreatePackage(packer, holderId, chargerId, PackagePacked, "2024-01-02", "1 Main St", "P1")
+			return err
+		})
+	}
+
+	expectError(t, pack(holderId, otherHolderId), "Assembly "+otherHolderId+" is a Holder, expecting a Charger.")
+
+	// A device type leaves the catalog once its assemblies are packed
+	err := l.invoke(func() error { return l.tnt.RemoveDeviceType(l.admin(), "MiniHolder") })
+	expectError(t, err, "Device type MiniHolder cannot be removed while assembly "+holderId+" of it is yet to be packed.")
+	if err := pack(holderId, chargerId); err != nil {
+		t.Errorf("CreatePackage: %s", err)
+	}
+	l.mustInvoke("RemoveDeviceType", func() error { return l.tnt.RemoveDeviceType(l.admin(), "MiniHolder") })
+}
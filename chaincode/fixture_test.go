@@ -0,0 +1,226 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// testIdentity is the client identity of a test caller, read from the
+// attributes of its certificate
+type testIdentity struct {
+	mspId string
+	attrs map[string]string
+}
+
+func (id *testIdentity) GetID() (string, error) {
+	return "x509::CN=" + id.attrs[attrEnrollmentId], nil
+}
+
+func (id *testIdentity) GetMSPID() (string, error) {
+	return id.mspId, nil
+}
+
+func (id *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := id.attrs[attrName]
+	return value, found, nil
+}
+
+func (id *testIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	if id.attrs[attrName] != attrValue {
+		return fmt.Errorf("Attribute %s is not %s", attrName, attrValue)
+	}
+	return nil
+}
+
+func (id *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: id.attrs[attrEnrollmentId]}}, nil
+}
+
+// testLedger is the chaincode running on an in-memory world state
+type testLedger struct {
+	t    *testing.T
+	stub *testStub
+	tnt  *TnT
+	txs  int
+}
+
+// newTestLedger deploys the chaincode on an empty world state and runs its
+// migrations
+func newTestLedger(t *testing.T) *testLedger {
+	l := &testLedger{t: t, stub: newTestStub(), tnt: new(TnT)}
+	err := l.invoke(func() error {
+		_, err := l.tnt.InitLedger(l.admin(), "PlantMSP")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("InitLedger: %s", err)
+	}
+
+	// Register the batches the test assemblies are built with
+	for componentType, prefix := range testBatchPrefixes {
+		for _, batchId := range []string{prefix + "-1", prefix + "-2"} {
+			l.registerBatch(componentType, batchId, 100)
+		}
+	}
+	return l
+}
+
+// testBatchPrefixes are the prefixes of the test batch ids of each component type
+var testBatchPrefixes = map[string]string{
+	"filament":     "FIL",
+	"led":          "LED",
+	"circuitBoard": "CB",
+	"wire":         "WIRE",
+	"casing":       "CASE",
+	"adaptor":      "ADP",
+	"stickPod":     "SP",
+}
+
+// testCertificateHash is the certificate of conformance hash of the test batches
+const testCertificateHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// registerBatch registers a batch of quantity components
+func (l *testLedger) registerBatch(componentType string, batchId string, quantity int) {
+	l.t.Helper()
+	l.mustInvoke("RegisterBatch "+batchId, func() error {
+		return l.tnt.RegisterBatch(l.admin(), componentType, batchId, "ACME", "2024-01-01", quantity, testCertificateHash)
+	})
+}
+
+// invoke runs fn as a transaction, committed when fn succeeds and dropped
+// when it fails, and returns the error of fn
+func (l *testLedger) invoke(fn func() error) error {
+	l.txs++
+	l.stub.begin(fmt.Sprintf("%08dfeedbeef", l.txs))
+	err := fn()
+	if err != nil {
+		l.stub.rollback()
+		return err
+	}
+	if err := l.stub.commit(); err != nil {
+		l.t.Fatalf("commit: %s", err)
+	}
+	return nil
+}
+
+// mustInvoke runs fn as a transaction and fails the test when it fails
+func (l *testLedger) mustInvoke(name string, fn func() error) {
+	l.t.Helper()
+	if err := l.invoke(fn); err != nil {
+		l.t.Fatalf("%s: %s", name, err)
+	}
+}
+
+// caller returns the context of a transaction submitted by an operator of
+// plant with the given comma separated roles
+func (l *testLedger) caller(name string, plant string, roles string) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(&testIdentity{
+		mspId: "PlantMSP",
+		attrs: map[string]string{attrEnrollmentId: name, attrPlant: plant, attrRole: roles},
+	})
+	return ctx
+}
+
+// admin returns the context of a transaction submitted by an admin
+func (l *testLedger) admin() contractapi.TransactionContextInterface {
+	return l.caller("admin1", hqPlant, RoleAdmin)
+}
+
+// createAssembly creates an assembly of plant in the Created status and
+// returns its id
+func (l *testLedger) createAssembly(ctx contractapi.TransactionContextInterface, serialNo string, deviceType string, plant string) string {
+	l.t.Helper()
+	var result *AssemblyCreateResult
+	l.mustInvoke("CreateAssembly "+serialNo, func() (err error) {
+		result, err = l.tnt.CreateAssembly(ctx, serialNo, deviceType, "FIL-1", "LED-1", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", plant, AssemblyCreated, "L1")
+		return err
+	})
+	return result.AssemblyId
+}
+
+// moveAssembly walks an assembly through the given statuses
+func (l *testLedger) moveAssembly(ctx contractapi.TransactionContextInterface, assemblyId string, statuses ...string) {
+	l.t.Helper()
+	for _, status := range statuses {
+		l.mustInvoke("PatchAssembly "+assemblyId+" to "+status, func() error {
+			return l.tnt.PatchAssembly(ctx, assemblyId, fmt.Sprintf(`{"assemblyStatus": %q}`, status))
+		})
+	}
+}
+
+// packableAssembly creates an assembly of plant that passed QA
+func (l *testLedger) packableAssembly(ctx contractapi.TransactionContextInterface, serialNo string, deviceType string, plant string) string {
+	l.t.Helper()
+	assemblyId := l.createAssembly(ctx, serialNo, deviceType, plant)
+	l.moveAssembly(ctx, assemblyId, AssemblyInAssembly, AssemblyQAPassed)
+	return assemblyId
+}
+
+// packedCase creates a QA passed holder and charger of plant and packs them
+// in a case, returning its id
+func (l *testLedger) packedCase(ctx contractapi.TransactionContextInterface, plant string) string {
+	l.t.Helper()
+	holderId := l.packableAssembly(ctx, "SN-H-"+plant, DeviceTypeHolder, plant)
+	chargerId := l.packableAssembly(ctx, "SN-C-"+plant, DeviceTypeCharger, plant)
+
+	var result *PackageCreateResult
+	l.mustInvoke("CreatePackage", func() (err error) {
+		result, err = l.tnt.CreatePackage(ctx, holderId, chargerId, PackagePacked, "2024-01-02", "1 Main St", "P1")
+		return err
+	})
+	return result.CaseId
+}
+
+// assembly reads an assembly straight from world state
+func (l *testLedger) assembly(assemblyId string) *AssemblyLine {
+	assembly, err := getAssembly(l.stub, assemblyId)
+	if err != nil {
+		l.t.Fatalf("getAssembly %s: %s", assemblyId, err)
+	}
+	return assembly
+}
+
+// packageLine reads a package straight from world state
+func (l *testLedger) packageLine(caseId string) *PackageLine {
+	_package, err := getPackage(l.stub, caseId)
+	if err != nil {
+		l.t.Fatalf("getPackage %s: %s", caseId, err)
+	}
+	return _package
+}
+
+// expectError fails the test unless err is the expected error
+func expectError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected error %q, got none", expected)
+	}
+	if err.Error() != expected {
+		t.Fatalf("expected error %q, got %q", expected, err.Error())
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Object types of the records kept by the chaincode
+const (
+	objectAssembly = "AssemblyLine"
+	objectPackage  = "PackageLine"
+)
+
+// FieldChange is the difference of a single field between two versions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// HistoryEntry is one version of a record, as written by a single transaction.
+// Entries are append-only and keyed by object type, object id and a zero
+// padded version so they read back in order.
+type HistoryEntry struct {
+	Version   int             `json:"version"`
+	TxId      string          `json:"txId"`
+	UpdatedOn string          `json:"updatedOn"`
+	UpdatedBy string          `json:"updatedBy"`
+	Changes   []FieldChange   `json:"changes"`
+	Record    json.RawMessage `json:"record"`
+}
+
+// AssemblyHistoryEntry is a HistoryEntry of an assembly, as returned to clients
+type AssemblyHistoryEntry struct {
+	Version   int           `json:"version"`
+	TxId      string        `json:"txId"`
+	UpdatedOn string        `json:"updatedOn"`
+	UpdatedBy string        `json:"updatedBy"`
+	Changes   []FieldChange `json:"changes"`
+	Record    *AssemblyLine `json:"record"`
+}
+
+// PackageHistoryEntry is a HistoryEntry of a package, as returned to clients
+type PackageHistoryEntry struct {
+	Version   int           `json:"version"`
+	TxId      string        `json:"txId"`
+	UpdatedOn string        `json:"updatedOn"`
+	UpdatedBy string        `json:"updatedBy"`
+	Changes   []FieldChange `json:"changes"`
+	Record    *PackageLine  `json:"record"`
+}
+
+// recordHistory appends a new version of an object to its history.
+// prev is nil when the object is created.
+func recordHistory(stub shim.ChaincodeStubInterface, objectType string, objectId string, updatedBy string, prev interface{}, cur interface{}) error {
+	entries, err := getHistory(stub, objectType, objectId)
+	if err != nil {
+		return err
+	}
+	version := len(entries) + 1
+
+	updatedOn, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	ok, err := insertRecord(stub, keyHistory, []string{objectType, objectId, fmt.Sprintf("%08d", version)}, &HistoryEntry{
+		Version:   version,
+		TxId:      stub.GetTxID(),
+		UpdatedOn: updatedOn,
+		UpdatedBy: updatedBy,
+		Changes:   diffFields(prev, cur),
+		Record:    record,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("History version %d of %s %s already exists.", version, objectType, objectId)
+	}
+	return nil
+}
+
+// getHistory returns every recorded version of an object, oldest first
+func getHistory(stub shim.ChaincodeStubInterface, objectType string, objectId string) ([]*HistoryEntry, error) {
+	entries := []*HistoryEntry{}
+	err := scanRecords(stub, keyHistory, []string{objectType, objectId}, func(keys []string, value []byte) error {
+		entry := new(HistoryEntry)
+		err := json.Unmarshal(value, entry)
+		if err != nil {
+			return fmt.Errorf("Corrupt history of %s %s: %s", objectType, objectId, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fieldString formats a field value for a FieldChange: strings as they are,
+// empty maps and slices as empty strings and other values in JSON
+func fieldString(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Map, reflect.Slice:
+		if value.Len() == 0 {
+			return ""
+		}
+	}
+	encoded, err := json.Marshal(value.Interface())
+	if err != nil {
+		return fmt.Sprint(value.Interface())
+	}
+	return string(encoded)
+}
+
+// diffFields compares two structs of the same type field by field and returns
+// the fields whose values differ, named after their json tags. A nil prev
+// reports every non-empty field of cur as changed.
+func diffFields(prev interface{}, cur interface{}) []FieldChange {
+	changes := []FieldChange{}
+
+	curVal := reflect.Indirect(reflect.ValueOf(cur))
+	var prevVal reflect.Value
+	if prev != nil && !reflect.ValueOf(prev).IsNil() {
+		prevVal = reflect.Indirect(reflect.ValueOf(prev))
+	}
+
+	for i := 0; i < curVal.NumField(); i++ {
+		field := curVal.Type().Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+
+		from := ""
+		if prevVal.IsValid() {
+			from = fieldString(prevVal.Field(i))
+		}
+		to := fieldString(curVal.Field(i))
+		if from != to {
+			changes = append(changes, FieldChange{Field: name, From: from, To: to})
+		}
+	}
+	return changes
+}
+
+// txTime returns the transaction timestamp. Unlike the local clock it is the
+// same on every endorsing peer.
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read transaction timestamp: %s", err)
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// txTimestamp returns the transaction timestamp in RFC3339 format
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	_time, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return _time.Format(time.RFC3339), nil
+}
+
+//get the version history of an Assembly
+func (t *TnT) GetAssemblyHistory(ctx contractapi.TransactionContextInterface, assemblyId string) ([]*AssemblyHistoryEntry, error) {
+	stub := ctx.GetStub()
+
+	_assembly, err := getAssembly(stub, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if _assembly != nil {
+		err = checkPlantRead(ctx, _assembly.ManufacturingPlant)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := getHistory(stub, objectAssembly, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*AssemblyHistoryEntry{}
+	for _, entry := range entries {
+		version := &AssemblyHistoryEntry{
+			Version:   entry.Version,
+			TxId:      entry.TxId,
+			UpdatedOn: entry.UpdatedOn,
+			UpdatedBy: entry.UpdatedBy,
+			Changes:   entry.Changes,
+		}
+		version.Record, err = decodeAssembly(entry.Record)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+//get the version history of a Package
+func (t *TnT) GetPackageHistory(ctx contractapi.TransactionContextInterface, caseId string) ([]*PackageHistoryEntry, error) {
+	entries, err := getHistory(ctx.GetStub(), objectPackage, caseId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*PackageHistoryEntry{}
+	for _, entry := range entries {
+		version := &PackageHistoryEntry{
+			Version:   entry.Version,
+			TxId:      entry.TxId,
+			UpdatedOn: entry.UpdatedOn,
+			UpdatedBy: entry.UpdatedBy,
+			Changes:   entry.Changes,
+		}
+		version.Record, err = decodePackage(entry.Record)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
@@ -0,0 +1,84 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// attrEnrollmentId is the certificate attribute naming the caller, set by the
+// Fabric CA on every enrollment certificate
+const attrEnrollmentId = "hf.EnrollmentID"
+
+// Identity is the operator or station that submitted a transaction
+type Identity struct {
+	EnrollmentId string `json:"enrollmentId"`
+	Org          string `json:"org"`
+}
+
+// String formats the identity as stored in the CreatedBy and LastUpdatedBy
+// fields, e.g. operator1@PlantAMSP
+func (id Identity) String() string {
+	if id.Org == "" {
+		return id.EnrollmentId
+	}
+	return id.EnrollmentId + "@" + id.Org
+}
+
+// callerIdentity returns the identity of the transaction creator: the
+// enrollment id attribute of its certificate, or its common name, and the MSP
+// of its organization. It never comes from the client arguments.
+func callerIdentity(ctx contractapi.TransactionContextInterface) (Identity, error) {
+	var id Identity
+	clientIdentity := ctx.GetClientIdentity()
+	if enrollmentId, found, err := clientIdentity.GetAttributeValue(attrEnrollmentId); err == nil && found {
+		id.EnrollmentId = enrollmentId
+	}
+
+	if id.EnrollmentId == "" {
+		cert, err := clientIdentity.GetX509Certificate()
+		if err != nil || cert == nil {
+			return id, errors.New("Unable to identify the caller: failed to read the caller certificate.")
+		}
+		id.EnrollmentId = cert.Subject.CommonName
+	}
+	if id.EnrollmentId == "" {
+		return id, errors.New("Unable to identify the caller: no enrollment id in the caller certificate.")
+	}
+
+	mspId, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return id, errors.New("Unable to identify the caller: failed to read the caller MSP.")
+	}
+	id.Org = mspId
+	return id, nil
+}
+
+// callerName returns the caller identity formatted for the CreatedBy and
+// LastUpdatedBy fields
+func callerName(ctx contractapi.TransactionContextInterface) (string, error) {
+	id, err := callerIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
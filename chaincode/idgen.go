@@ -0,0 +1,106 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Prefixes of the IDs minted by the chaincode
+const (
+	assemblyIDPrefix = "ASM"
+	caseIDPrefix     = "CASE"
+	recallIDPrefix   = "RCL"
+)
+
+// World state keys of the ID sequence counters
+const (
+	assemblySeqKey = "seq_AssemblyLine"
+	caseSeqKey     = "seq_PackageLine"
+	recallSeqKey   = "seq_Recall"
+)
+
+// nextID mints the next ID of a sequence. The ID is built only from the
+// transaction context (the sequence counter kept in world state and the tx ID),
+// so every endorsing peer computes the same value. Optional scopes such as the
+// plant and line are added after the prefix and the zero padded counter keeps
+// IDs sortable, e.g. ASM-PLANT1-L3-0000000042-5f3c9a1b.
+func nextID(stub shim.ChaincodeStubInterface, seqKey string, prefix string, scopes ...string) (string, error) {
+	seq, err := nextSequence(stub, seqKey)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{prefix}
+	for _, scope := range scopes {
+		if scope = sanitizeIDScope(scope); scope != "" {
+			parts = append(parts, scope)
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%010d", seq))
+	if txID := stub.GetTxID(); txID != "" {
+		if len(txID) > 8 {
+			txID = txID[:8]
+		}
+		parts = append(parts, strings.ToLower(txID))
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// nextSequence increments the counter stored under seqKey and returns the new value
+func nextSequence(stub shim.ChaincodeStubInterface, seqKey string) (uint64, error) {
+	var seq uint64
+	seqBytes, err := stub.GetState(seqKey)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read sequence %s: %s", seqKey, err)
+	}
+	if len(seqBytes) > 0 {
+		seq, err = strconv.ParseUint(string(seqBytes), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Corrupt sequence %s: %s", seqKey, err)
+		}
+	}
+	seq++
+
+	err = stub.PutState(seqKey, []byte(strconv.FormatUint(seq, 10)))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to store sequence %s: %s", seqKey, err)
+	}
+	return seq, nil
+}
+
+// sanitizeIDScope turns a plant or line name into an ID segment: upper case
+// letters and digits only
+func sanitizeIDScope(scope string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return -1
+	}, scope)
+}
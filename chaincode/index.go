@@ -0,0 +1,150 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// assemblyIndex is a secondary index of AssemblyLine. Its entries are composite
+// keys of the index name, the indexed field and the assemblyId, e.g.
+// assembly~plant~<plant>~<assemblyId>.
+type assemblyIndex struct {
+	Name string
+	Key  func(a *AssemblyLine) string
+}
+
+// Secondary indexes of AssemblyLine on its own fields. The assemblies are also
+// indexed by component batch, see indexComponentBatches.
+var (
+	assemblyByStatus = assemblyIndex{"assembly~status", func(a *AssemblyLine) string { return a.AssemblyStatus }}
+	assemblyByPlant  = assemblyIndex{"assembly~plant", func(a *AssemblyLine) string { return a.ManufacturingPlant }}
+	assemblyBySerial = assemblyIndex{"assembly~serialNo", func(a *AssemblyLine) string { return a.DeviceSerialNo }}
+)
+
+// assemblyIndexes returns every secondary index of AssemblyLine
+func assemblyIndexes() []assemblyIndex {
+	return []assemblyIndex{assemblyByStatus, assemblyByPlant, assemblyBySerial}
+}
+
+// indexAssembly moves the secondary index entries of an assembly from the
+// values of its previous version, nil for a new assembly, to the current ones
+func indexAssembly(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	err := indexComponentBatches(stub, previous, current)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range assemblyIndexes() {
+		key := index.Key(current)
+		if previous != nil {
+			previousKey := index.Key(previous)
+			if previousKey == key {
+				continue
+			}
+			if previousKey != "" {
+				err := deleteRecord(stub, index.Name, []string{previousKey, current.AssemblyId})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if key == "" {
+			continue
+		}
+
+		err := putIndex(stub, index.Name, key, current.AssemblyId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSerialNo fails when the device serial number of an assembly is already
+// used by another one. A serial number scanned on a product names a single
+// assembly. The range read of the serial number index is checked again when
+// the transaction commits, so concurrent transactions cannot both use it.
+func checkSerialNo(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	if current.DeviceSerialNo == "" || (previous != nil && previous.DeviceSerialNo == current.DeviceSerialNo) {
+		return nil
+	}
+
+	assemblyIds, err := getAssemblyIdsByIndex(stub, assemblyBySerial, current.DeviceSerialNo)
+	if err != nil {
+		return err
+	}
+	for _, assemblyId := range assemblyIds {
+		if assemblyId != current.AssemblyId {
+			return fmt.Errorf("Device serial number %s is already used by assembly %s.", current.DeviceSerialNo, assemblyId)
+		}
+	}
+	return nil
+}
+
+// getAssemblyIdBySerialNo returns the id of the assembly of a device serial
+// number, or an empty string when there is none. Serial numbers used twice
+// before they were unique are reported as an error.
+func getAssemblyIdBySerialNo(stub shim.ChaincodeStubInterface, deviceSerialNo string) (string, error) {
+	assemblyIds, err := getAssemblyIdsByIndex(stub, assemblyBySerial, deviceSerialNo)
+	if err != nil || len(assemblyIds) == 0 {
+		return "", err
+	}
+	if len(assemblyIds) > 1 {
+		return "", fmt.Errorf("Device serial number %s is used by several assemblies: [%s].", deviceSerialNo, strings.Join(assemblyIds, ", "))
+	}
+	return assemblyIds[0], nil
+}
+
+// getAssemblyIdsByIndex returns the ids of the assemblies indexed under key
+func getAssemblyIdsByIndex(stub shim.ChaincodeStubInterface, index assemblyIndex, key string) ([]string, error) {
+	assemblyIds := []string{}
+	err := scanRecords(stub, index.Name, []string{key}, func(keys []string, value []byte) error {
+		assemblyIds = append(assemblyIds, keys[1])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assemblyIds, nil
+}
+
+// getAssembliesByIndex returns the assemblies indexed under key
+func getAssembliesByIndex(stub shim.ChaincodeStubInterface, index assemblyIndex, key string) ([]*AssemblyLine, error) {
+	assemblyIds, err := getAssemblyIdsByIndex(stub, index, key)
+	if err != nil {
+		return nil, err
+	}
+
+	assemblies := []*AssemblyLine{}
+	for _, assemblyId := range assemblyIds {
+		assembly, err := getAssembly(stub, assemblyId)
+		if err != nil {
+			return nil, err
+		}
+		if assembly != nil {
+			assemblies = append(assemblies, assembly)
+		}
+	}
+	return assemblies, nil
+}
@@ -0,0 +1,309 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with one field of a JSON document argument
+type FieldError struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+// InputError lists every problem found in a JSON document argument, so
+// clients can fix them all at once
+type InputError struct {
+	Object string
+	Fields []FieldError
+}
+
+func (e *InputError) Error() string {
+	problems := []string{}
+	for _, field := range e.Fields {
+		problems = append(problems, field.Field+" "+field.Problem)
+	}
+	return fmt.Sprintf("Invalid %s: %s.", e.Object, strings.Join(problems, "; "))
+}
+
+// inputSpec describes the JSON document argument of a create or update
+type inputSpec struct {
+	Object string
+	// Required fields must be present and not empty
+	Required []string
+	// Dates must be empty or in YYYY-MM-DD format
+	Dates []string
+	// Ignored fields are set by the chaincode. They are accepted, so records
+	// read back can be sent as they are, but their values are not used.
+	Ignored []string
+	// Extra fields are accepted although they are not fields of the record
+	Extra []string
+	// Immutable fields cannot be changed by a patch
+	Immutable []string
+}
+
+// Read-only fields of the records, set by the chaincode
+var (
+	assemblyIgnored = []string{"assemblyCreationDate", "assemblyLastUpdateOn", "assemblyCreatedBy", "assemblyLastUpdatedBy", "caseId", "componentQuantities"}
+	packageIgnored  = []string{"packagingCreationDate", "packageLastUpdateOn", "packageCreatedBy", "packageLastUpdatedBy"}
+)
+
+var (
+	createAssemblySpec = inputSpec{
+		Object:   "assembly",
+		Required: []string{"deviceSerialNo", "deviceType", "manufacturingPlant", "assemblyStatus"},
+		Ignored:  append([]string{"assemblyId"}, assemblyIgnored...),
+		Extra:    []string{"assemblyLine"},
+	}
+	updateAssemblySpec = inputSpec{
+		Object:   "assembly",
+		Required: []string{"assemblyId", "deviceSerialNo", "deviceType", "manufacturingPlant", "assemblyStatus"},
+		Ignored:  assemblyIgnored,
+	}
+	createPackageSpec = inputSpec{
+		Object:   "package",
+		Required: []string{"holderAssemblyId", "chargerAssemblyId", "packageStatus"},
+		Dates:    []string{"packagingDate"},
+		Ignored:  append([]string{"caseId"}, packageIgnored...),
+		Extra:    []string{"packingLine"},
+	}
+	updatePackageSpec = inputSpec{
+		Object:   "package",
+		Required: []string{"caseId", "holderAssemblyId", "chargerAssemblyId", "packageStatus"},
+		Dates:    []string{"packagingDate"},
+		Ignored:  packageIgnored,
+	}
+)
+
+// decodeInput decodes a JSON document into record, a pointer to a struct of
+// string and map fields, following spec. It returns the values of the extra
+// fields, or an InputError listing every unknown, malformed or missing field.
+func decodeInput(document string, spec inputSpec, record interface{}) (map[string]string, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal([]byte(document), &fields)
+	if err != nil || fields == nil {
+		return nil, &InputError{Object: spec.Object, Fields: []FieldError{{Field: "document", Problem: "is not a JSON object"}}}
+	}
+
+	byTag := fieldsByTag(record)
+
+	problems := []FieldError{}
+	values := map[string]string{}
+	for _, name := range sortedNames(fields) {
+		field, known := byTag[name]
+		if !known && !stringsContain(spec.Extra, name) {
+			problems = append(problems, FieldError{Field: name, Problem: "is not a known field"})
+			continue
+		}
+		if known && field.Kind() == reflect.Map {
+			value := reflect.New(field.Type())
+			if json.Unmarshal(fields[name], value.Interface()) != nil || string(fields[name]) == "null" {
+				problems = append(problems, FieldError{Field: name, Problem: "must be " + mapDescription(field.Type())})
+				continue
+			}
+			if !stringsContain(spec.Ignored, name) {
+				field.Set(value.Elem())
+			}
+			continue
+		}
+		var value string
+		if json.Unmarshal(fields[name], &value) != nil || string(fields[name]) == "null" {
+			problems = append(problems, FieldError{Field: name, Problem: "must be a string"})
+			continue
+		}
+		values[name] = value
+		if known && !stringsContain(spec.Ignored, name) {
+			field.SetString(value)
+		}
+	}
+
+	for _, name := range spec.Required {
+		if _, present := fields[name]; !present {
+			problems = append(problems, FieldError{Field: name, Problem: "is missing"})
+		} else if value, ok := values[name]; ok && strings.TrimSpace(value) == "" {
+			problems = append(problems, FieldError{Field: name, Problem: "must not be empty"})
+		}
+	}
+	for _, name := range spec.Dates {
+		if value := values[name]; value != "" {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				problems = append(problems, FieldError{Field: name, Problem: "must be a date in YYYY-MM-DD format"})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &InputError{Object: spec.Object, Fields: problems}
+	}
+
+	extra := map[string]string{}
+	for _, name := range spec.Extra {
+		extra[name] = values[name]
+	}
+	return extra, nil
+}
+
+// Patches of the records, validated against their current version
+var (
+	assemblyPatchSpec = inputSpec{
+		Object:    "assembly patch",
+		Required:  []string{"deviceSerialNo", "deviceType", "manufacturingPlant", "assemblyStatus"},
+		Immutable: append([]string{"assemblyId"}, assemblyIgnored...),
+	}
+	packagePatchSpec = inputSpec{
+		Object:   "package patch",
+		Required: []string{"packageStatus"},
+		Dates:    []string{"packagingDate"},
+		// The assemblies of a case are fixed when it is packed
+		Immutable: append([]string{"caseId", "holderAssemblyId", "chargerAssemblyId"}, packageIgnored...),
+	}
+)
+
+// applyPatch applies a JSON merge patch (RFC 7396) to record, a pointer to a
+// struct of string and map fields holding the current version. A field set to
+// null is cleared, and so is a member of a map field. It returns an InputError
+// listing every unknown or malformed field, every immutable field changed and
+// every required field cleared.
+func applyPatch(patch string, spec inputSpec, record interface{}) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal([]byte(patch), &fields)
+	if err != nil || fields == nil {
+		return &InputError{Object: spec.Object, Fields: []FieldError{{Field: "document", Problem: "is not a JSON object"}}}
+	}
+
+	byTag := fieldsByTag(record)
+
+	problems := []FieldError{}
+	for _, name := range sortedNames(fields) {
+		field, known := byTag[name]
+		if !known {
+			problems = append(problems, FieldError{Field: name, Problem: "is not a known field"})
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			value, ok := patchMap(field, fields[name])
+			if !ok {
+				problems = append(problems, FieldError{Field: name, Problem: "must be " + mapDescription(field.Type()) + " or null"})
+				continue
+			}
+			if stringsContain(spec.Immutable, name) {
+				if value.Len() != field.Len() || (value.Len() > 0 && !reflect.DeepEqual(value.Interface(), field.Interface())) {
+					problems = append(problems, FieldError{Field: name, Problem: "cannot be changed"})
+				}
+				continue
+			}
+			field.Set(value)
+			continue
+		}
+		value := ""
+		if string(fields[name]) != "null" && json.Unmarshal(fields[name], &value) != nil {
+			problems = append(problems, FieldError{Field: name, Problem: "must be a string or null"})
+			continue
+		}
+		if stringsContain(spec.Immutable, name) {
+			// Sending back the current value is harmless
+			if value != field.String() {
+				problems = append(problems, FieldError{Field: name, Problem: "cannot be changed"})
+			}
+			continue
+		}
+		field.SetString(value)
+
+		if stringsContain(spec.Required, name) && strings.TrimSpace(value) == "" {
+			problems = append(problems, FieldError{Field: name, Problem: "must not be empty"})
+		}
+		if stringsContain(spec.Dates, name) && value != "" {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				problems = append(problems, FieldError{Field: name, Problem: "must be a date in YYYY-MM-DD format"})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &InputError{Object: spec.Object, Fields: problems}
+	}
+	return nil
+}
+
+// patchMap merges a JSON merge patch into a copy of a map field, deleting the
+// members set to null. It reports false when the patch is malformed.
+func patchMap(field reflect.Value, patch json.RawMessage) (reflect.Value, bool) {
+	if string(patch) == "null" {
+		return reflect.MakeMap(field.Type()), true
+	}
+	var members map[string]json.RawMessage
+	if json.Unmarshal(patch, &members) != nil || members == nil {
+		return reflect.Value{}, false
+	}
+
+	merged := reflect.MakeMap(field.Type())
+	iter := field.MapRange()
+	for iter.Next() {
+		merged.SetMapIndex(iter.Key(), iter.Value())
+	}
+	for key, member := range members {
+		if string(member) == "null" {
+			merged.SetMapIndex(reflect.ValueOf(key), reflect.Value{})
+			continue
+		}
+		value := reflect.New(field.Type().Elem())
+		if json.Unmarshal(member, value.Interface()) != nil {
+			return reflect.Value{}, false
+		}
+		merged.SetMapIndex(reflect.ValueOf(key), value.Elem())
+	}
+	return merged, true
+}
+
+// mapDescription describes the JSON value of a map field in problems
+func mapDescription(mapType reflect.Type) string {
+	if mapType.Elem().Kind() == reflect.String {
+		return "an object of strings"
+	}
+	return "an object of numbers"
+}
+
+// fieldsByTag maps the json tags of record, a pointer to a struct, to its fields
+func fieldsByTag(record interface{}) map[string]reflect.Value {
+	recordVal := reflect.ValueOf(record).Elem()
+	byTag := map[string]reflect.Value{}
+	for i := 0; i < recordVal.NumField(); i++ {
+		tag := strings.Split(recordVal.Type().Field(i).Tag.Get("json"), ",")[0]
+		byTag[tag] = recordVal.Field(i)
+	}
+	return byTag
+}
+
+// sortedNames returns the field names of a JSON object in order, so problems
+// are always reported in the same order
+func sortedNames(fields map[string]json.RawMessage) []string {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
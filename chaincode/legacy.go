@@ -0,0 +1,392 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LegacyExport holds the rows exported from the tables of the legacy table
+// based chaincode, each row being its column values in table definition
+// order, and its sequence counters
+type LegacyExport struct {
+	Tables map[string][][]string `json:"tables"`
+	State  map[string]string     `json:"state"`
+}
+
+// LegacyTableImport reports the rows of a legacy table imported by
+// ImportLegacyRows. Rows whose record already exists are skipped, as are the
+// rows of the index tables, which are rebuilt from the imported records.
+type LegacyTableImport struct {
+	Table    string `json:"table"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+}
+
+// legacyTables are the legacy tables holding records, in import order.
+// Packages come first so assemblies exported before they had a caseId column
+// get the case containing them.
+var legacyTables = []string{"PackageLine", "AssemblyLine", "History", "PackageMilestone", "Recall", "Lifecycle", "AccessPolicy"}
+
+// legacySequences are the sequence counters kept from the legacy world state
+var legacySequences = []string{assemblySeqKey, caseSeqKey, recallSeqKey}
+
+// isLegacyIndexTable reports whether a legacy table is an index rebuilt from
+// the imported records
+func isLegacyIndexTable(table string) bool {
+	return table == "PackageByAssembly" || table == "RecalledBatch" || strings.HasPrefix(table, "AssemblyBy")
+}
+
+// legacyImport is the state of an ImportLegacyRows transaction. World state
+// reads do not see the writes of the same transaction, so the cases indexed by
+// this import are kept here.
+type legacyImport struct {
+	stub    shim.ChaincodeStubInterface
+	caseIds map[string]string
+}
+
+//Admin API to import the rows exported from the legacy table based chaincode.
+//It can be run again, or on parts of the export, as existing records are
+//skipped.
+func (t *TnT) ImportLegacyRows(ctx contractapi.TransactionContextInterface, exportJSON string) ([]*LegacyTableImport, error) {
+	var export LegacyExport
+	err := json.Unmarshal([]byte(exportJSON), &export)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid legacy export: %s", err)
+	}
+
+	im := &legacyImport{stub: ctx.GetStub(), caseIds: map[string]string{}}
+	report := []*LegacyTableImport{}
+
+	tables := []string{}
+	for table := range export.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if !isLegacyIndexTable(table) && !stringsContain(legacyTables, table) {
+			return nil, fmt.Errorf("Unknown legacy table %q.", table)
+		}
+		if isLegacyIndexTable(table) {
+			report = append(report, &LegacyTableImport{Table: table, Skipped: len(export.Tables[table])})
+		}
+	}
+
+	for _, table := range legacyTables {
+		rows, ok := export.Tables[table]
+		if !ok {
+			continue
+		}
+		result := &LegacyTableImport{Table: table}
+		for i, row := range rows {
+			imported, err := im.importRow(table, row)
+			if err != nil {
+				return nil, fmt.Errorf("%s row %d: %s", table, i, err)
+			}
+			if imported {
+				result.Imported++
+			} else {
+				result.Skipped++
+			}
+		}
+		report = append(report, result)
+	}
+
+	result, err := im.importSequences(export.State)
+	if err != nil {
+		return nil, err
+	}
+	return append(report, result), nil
+}
+
+// importRow imports one row of a legacy table and reports whether it was
+// imported or skipped because its record exists
+func (im *legacyImport) importRow(table string, row []string) (bool, error) {
+	switch table {
+	case "PackageLine":
+		return im.importPackage(row)
+	case "AssemblyLine":
+		return im.importAssembly(row)
+	case "History":
+		return im.importHistory(row)
+	case "PackageMilestone":
+		return im.importMilestone(row)
+	case "Recall":
+		return im.importRecall(row)
+	case "Lifecycle":
+		if err := checkLegacyColumns(row, 3); err != nil {
+			return false, err
+		}
+		transition := StatusTransition{ObjectType: row[0], FromStatus: row[1], ToStatus: row[2]}
+		return insertRecord(im.stub, keyTransition, transitionKeys(transition), transition)
+	case "AccessPolicy":
+		if err := checkLegacyColumns(row, 2); err != nil {
+			return false, err
+		}
+		// The legacy functions were named in lower camel case
+		grant := Grant{Function: upperFirst(row[0]), Role: row[1]}
+		return insertRecord(im.stub, keyGrant, grantKeys(grant), grant)
+	}
+	return false, fmt.Errorf("Unknown legacy table %q.", table)
+}
+
+// importPackage imports a PackageLine row and indexes its assemblies to it
+func (im *legacyImport) importPackage(row []string) (bool, error) {
+	if err := checkLegacyColumns(row, 10); err != nil {
+		return false, err
+	}
+	_package := &PackageLine{
+		CaseId:               row[0],
+		HolderAssemblyId:     row[1],
+		ChargerAssemblyId:    row[2],
+		PackageStatus:        row[3],
+		PackagingDate:        row[4],
+		PackageCreationDate:  row[5],
+		PackageLastUpdatedOn: row[6],
+		ShippingToAddress:    row[7],
+		PackageCreatedBy:     row[8],
+		PackageLastUpdatedBy: row[9],
+	}
+	ok, err := insertRecord(im.stub, keyPackage, []string{_package.CaseId}, _package)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// The legacy chaincode let an assembly be packed in several cases; the
+	// first case imported keeps it
+	for _, assemblyId := range []string{_package.HolderAssemblyId, _package.ChargerAssemblyId} {
+		caseId, err := im.caseIdOf(assemblyId)
+		if err != nil {
+			return false, err
+		}
+		if assemblyId == "" || caseId != "" {
+			continue
+		}
+		err = putRecord(im.stub, keyPackageByAssembly, []string{assemblyId}, _package.CaseId)
+		if err != nil {
+			return false, err
+		}
+		im.caseIds[assemblyId] = _package.CaseId
+	}
+	return true, nil
+}
+
+// caseIdOf returns the case containing an assembly, indexed by this import or before
+func (im *legacyImport) caseIdOf(assemblyId string) (string, error) {
+	if caseId, ok := im.caseIds[assemblyId]; ok {
+		return caseId, nil
+	}
+	return getCaseIdByAssembly(im.stub, assemblyId)
+}
+
+// importAssembly imports an AssemblyLine row, with or without the caseId
+// column, and indexes it
+func (im *legacyImport) importAssembly(row []string) (bool, error) {
+	if len(row) != 16 {
+		if err := checkLegacyColumns(row, 17); err != nil {
+			return false, err
+		}
+	}
+	assembly := &AssemblyLine{
+		AssemblyId:            row[0],
+		DeviceSerialNo:        row[1],
+		DeviceType:            row[2],
+		FilamentBatchId:       row[3],
+		LedBatchId:            row[4],
+		CircuitBoardBatchId:   row[5],
+		WireBatchId:           row[6],
+		CasingBatchId:         row[7],
+		AdaptorBatchId:        row[8],
+		StickPodBatchId:       row[9],
+		ManufacturingPlant:    row[10],
+		AssemblyStatus:        row[11],
+		AssemblyCreationDate:  row[12],
+		AssemblyLastUpdatedOn: row[13],
+		AssemblyCreatedBy:     row[14],
+		AssemblyLastUpdatedBy: row[15],
+	}
+	if len(row) == 17 {
+		assembly.CaseId = row[16]
+	}
+	if assembly.CaseId == "" {
+		caseId, err := im.caseIdOf(assembly.AssemblyId)
+		if err != nil {
+			return false, err
+		}
+		assembly.CaseId = caseId
+	}
+
+	componentsOfColumns(assembly)
+
+	ok, err := insertRecord(im.stub, keyAssembly, []string{assembly.AssemblyId}, assembly)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, indexAssembly(im.stub, nil, assembly)
+}
+
+// importHistory imports a History row
+func (im *legacyImport) importHistory(row []string) (bool, error) {
+	if err := checkLegacyColumns(row, 8); err != nil {
+		return false, err
+	}
+	version, err := strconv.Atoi(row[2])
+	if err != nil {
+		return false, fmt.Errorf("Invalid history version %q.", row[2])
+	}
+	entry := &HistoryEntry{
+		Version:   version,
+		TxId:      row[3],
+		UpdatedOn: row[4],
+		UpdatedBy: row[5],
+		Record:    json.RawMessage(row[7]),
+	}
+	err = json.Unmarshal([]byte(row[6]), &entry.Changes)
+	if err != nil {
+		return false, fmt.Errorf("Invalid history changes: %s", err)
+	}
+	if !json.Valid(entry.Record) {
+		return false, fmt.Errorf("Invalid history record of %s %s.", row[0], row[1])
+	}
+	return insertRecord(im.stub, keyHistory, []string{row[0], row[1], fmt.Sprintf("%08d", version)}, entry)
+}
+
+// importMilestone imports a PackageMilestone row
+func (im *legacyImport) importMilestone(row []string) (bool, error) {
+	if err := checkLegacyColumns(row, 8); err != nil {
+		return false, err
+	}
+	sequence, err := strconv.Atoi(row[1])
+	if err != nil {
+		return false, fmt.Errorf("Invalid milestone sequence %q.", row[1])
+	}
+	milestone := &PackageMilestone{
+		CaseId:     row[0],
+		Sequence:   sequence,
+		Status:     row[2],
+		Note:       row[3],
+		Location:   row[4],
+		RecordedOn: row[5],
+		RecordedBy: row[6],
+		TxId:       row[7],
+	}
+	return insertRecord(im.stub, keyMilestone, []string{milestone.CaseId, fmt.Sprintf("%08d", sequence)}, milestone)
+}
+
+// importRecall imports a Recall row and, when it is open, marks its batches
+// as recalled
+func (im *legacyImport) importRecall(row []string) (bool, error) {
+	if err := checkLegacyColumns(row, 11); err != nil {
+		return false, err
+	}
+	recall := &Recall{
+		RecallId:      row[0],
+		ComponentType: row[1],
+		Reason:        row[3],
+		Status:        row[4],
+		OpenedBy:      row[5],
+		OpenedOn:      row[6],
+		ClosedBy:      row[7],
+		ClosedOn:      row[8],
+		Resolution:    row[9],
+	}
+	if err := json.Unmarshal([]byte(row[2]), &recall.BatchIds); err != nil {
+		return false, fmt.Errorf("Invalid batch ids of recall %s: %s", recall.RecallId, err)
+	}
+	if err := json.Unmarshal([]byte(row[10]), &recall.AssemblyIds); err != nil {
+		return false, fmt.Errorf("Invalid assembly ids of recall %s: %s", recall.RecallId, err)
+	}
+
+	ok, err := insertRecord(im.stub, keyRecall, []string{recall.RecallId}, recall)
+	if err != nil || !ok {
+		return false, err
+	}
+	if recall.Status == RecallOpen {
+		for _, batchId := range recall.BatchIds {
+			err = putRecord(im.stub, keyRecalledBatch, []string{recall.ComponentType, batchId}, recall.RecallId)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// importSequences raises the sequence counters to their legacy values so the
+// IDs minted from now on keep counting from the legacy ones
+func (im *legacyImport) importSequences(state map[string]string) (*LegacyTableImport, error) {
+	result := &LegacyTableImport{Table: "state"}
+	for key, value := range state {
+		if !stringsContain(legacySequences, key) {
+			result.Skipped++
+			continue
+		}
+		legacySeq, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid legacy sequence %s: %q.", key, value)
+		}
+
+		current, err := im.stub.GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read sequence %s: %s", key, err)
+		}
+		if len(current) > 0 {
+			seq, err := strconv.ParseUint(string(current), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Corrupt sequence %s: %s", key, err)
+			}
+			if seq >= legacySeq {
+				result.Skipped++
+				continue
+			}
+		}
+
+		err = im.stub.PutState(key, []byte(strconv.FormatUint(legacySeq, 10)))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store sequence %s: %s", key, err)
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+// checkLegacyColumns fails unless a legacy row has the expected number of columns
+func checkLegacyColumns(row []string, expected int) error {
+	if len(row) != expected {
+		return fmt.Errorf("Expecting %d columns. Got: %d.", expected, len(row))
+	}
+	return nil
+}
+
+// upperFirst upper cases the first letter of a legacy function name, e.g.
+// createAssembly becomes CreateAssembly
+func upperFirst(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
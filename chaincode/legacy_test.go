@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// legacyExport returns the JSON export of a small legacy ledger: a case
+// packing two assemblies exported before the caseId column, an open recall and
+// an edited access policy
+func legacyExport(t *testing.T) string {
+	assembly := func(id string, deviceType string) []string {
+		return []string{id, "SN-" + id, deviceType, "FIL-1", "LED-9", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", "PLANT1", AssemblyPackaged, "2017-03-01", "2017-03-02", "op1", "op1"}
+	}
+	export := LegacyExport{
+		Tables: map[string][][]string{
+			"PackageLine":      {{"CASE-7", "ASM-1", "ASM-2", PackagePacked, "2017-03-02", "2017-03-02", "2017-03-02", "1 Main St", "packer1", "packer1"}},
+			"AssemblyLine":     {assembly("ASM-1", DeviceTypeHolder), assembly("ASM-2", DeviceTypeCharger)},
+			"History":          {{objectPackage, "CASE-7", "1", "tx1", "2017-03-02T10:00:00Z", "packer1", `[{"field":"packageStatus","from":"","to":"Packed"}]`, `{"caseId":"CASE-7"}`}},
+			"PackageMilestone": {{"CASE-7", "1", PackageReadyToShip, "", "Dock 1", "2017-03-03T10:00:00Z", "packer1", "tx2"}},
+			"Recall":           {{"RCL-3", "led", `["LED-9"]`, "flicker", RecallOpen, "admin1", "2017-03-04T10:00:00Z", "", "", "", `["ASM-1","ASM-2"]`}},
+			"Lifecycle":        {{objectAssembly, AssemblyQAFailed, AssemblyRecalled}},
+			"AccessPolicy":     {{"closeRecall", RoleAuditor}},
+			"AssemblyByStatus": {{AssemblyPackaged, "ASM-1"}, {AssemblyPackaged, "ASM-2"}},
+		},
+		State: map[string]string{assemblySeqKey: "41", "schemaVersion": "3"},
+	}
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	return string(data)
+}
+
+func TestImportLegacyRows(t *testing.T) {
+	l := newTestLedger(t)
+	export := legacyExport(t)
+
+	var report []*LegacyTableImport
+	l.mustInvoke("ImportLegacyRows", func() (err error) {
+		report, err = l.tnt.ImportLegacyRows(l.admin(), export)
+		return err
+	})
+	imported := map[string]LegacyTableImport{}
+	for _, table := range report {
+		imported[table.Table] = *table
+	}
+	expected := map[string]LegacyTableImport{
+		"AssemblyByStatus": {"AssemblyByStatus", 0, 2},
+		"PackageLine":      {"PackageLine", 1, 0},
+		"AssemblyLine":     {"AssemblyLine", 2, 0},
+		"History":          {"History", 1, 0},
+		"PackageMilestone": {"PackageMilestone", 1, 0},
+		"Recall":           {"Recall", 1, 0},
+		"Lifecycle":        {"Lifecycle", 1, 0},
+		"AccessPolicy":     {"AccessPolicy", 1, 0},
+		"state":            {"state", 1, 1},
+	}
+	if len(imported) != len(expected) {
+		t.Errorf("unexpected report %v", imported)
+	}
+	for table, want := range expected {
+		if imported[table] != want {
+			t.Errorf("%s: expected %+v, got %+v", table, want, imported[table])
+		}
+	}
+
+	// The assemblies get their case and the derived indexes are rebuilt
+	ctx := l.caller("auditor1", "", RoleAuditor)
+	if caseId := l.assembly("ASM-2").CaseId; caseId != "CASE-7" {
+		t.Errorf("expected ASM-2 in CASE-7, got %q", caseId)
+	}
+	if packed, err := l.tnt.GetPackageByAssemblyID(ctx, "ASM-1"); err != nil || packed.CaseId != "CASE-7" {
+		t.Errorf("GetPackageByAssemblyID: %+v %v", packed, err)
+	}
+	if byStatus, _ := l.tnt.GetAllAssemblyByStatus(ctx, AssemblyPackaged); len(byStatus) != 2 {
+		t.Errorf("expected 2 Packaged assemblies, got %d", len(byStatus))
+	}
+	if recallId, _ := getOpenRecallId(l.stub, "led", "LED-9"); recallId != "RCL-3" {
+		t.Errorf("expected LED-9 under recall RCL-3, got %q", recallId)
+	}
+	if grants, _ := l.tnt.GetAccessPolicy(ctx, "CloseRecall"); len(grants) != 2 {
+		t.Errorf("expected the imported CloseRecall grant, got %v", grants)
+	}
+	if history, _ := l.tnt.GetPackageHistory(ctx, "CASE-7"); len(history) != 1 || history[0].Changes[0].To != PackagePacked {
+		t.Errorf("unexpected imported history %v", history)
+	}
+	if milestones, _ := l.tnt.GetPackageMilestones(ctx, "CASE-7"); len(milestones) != 1 || milestones[0].Location != "Dock 1" {
+		t.Errorf("unexpected imported milestones %v", milestones)
+	}
+
+	// New ids continue the legacy sequence
+	assemblyId := l.createAssembly(l.caller("op1", "PLANT1", RoleAssembler), "SN-NEW", DeviceTypeHolder, "PLANT1")
+	if !strings.Contains(assemblyId, "-0000000042-") {
+		t.Errorf("expected the 42nd assembly id, got %s", assemblyId)
+	}
+
+	// Importing again skips every record
+	l.mustInvoke("ImportLegacyRows", func() (err error) {
+		report, err = l.tnt.ImportLegacyRows(l.admin(), export)
+		return err
+	})
+	for _, table := range report {
+		if table.Imported != 0 {
+			t.Errorf("second import imported %d %s rows", table.Imported, table.Table)
+		}
+	}
+}
+
+func TestImportLegacyRowsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		export  string
+		wantErr string
+	}{
+		{"unknown table", `{"tables": {"Widgets": []}}`, `Unknown legacy table "Widgets".`},
+		{"short row", `{"tables": {"AssemblyLine": [["ASM-1", "SN-1"]]}}`, "AssemblyLine row 0: Expecting 17 columns. Got: 2."},
+		{"bad history version", `{"tables": {"History": [["AssemblyLine", "ASM-1", "one", "", "", "", "[]", "{}"]]}}`, `History row 0: Invalid history version "one".`},
+		{"bad sequence", `{"state": {"seq_Recall": "-1"}}`, `Invalid legacy sequence seq_Recall: "-1".`},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				_, err := l.tnt.ImportLegacyRows(l.admin(), test.export)
+				return err
+			})
+			expectError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// lifecycleStart is the from status of the transitions allowed on creation
+const lifecycleStart = "*"
+
+// Assembly statuses of the default lifecycle
+const (
+	AssemblyCreated    = "Created"
+	AssemblyInAssembly = "InAssembly"
+	AssemblyQAPassed   = "QA-Passed"
+	AssemblyQAFailed   = "QA-Failed"
+	AssemblyPackaged   = "Packaged"
+	AssemblyShipped    = "Shipped"
+	AssemblyReturned   = "Returned"
+	AssemblyRecalled   = "Recalled"
+)
+
+// Package statuses of the default lifecycle
+const (
+	PackagePacked      = "Packed"
+	PackageReadyToShip = "ReadyToShip"
+	PackageInTransit   = "InTransit"
+	PackageDelivered   = "Delivered"
+	PackageReturned    = "Returned"
+	PackageLost        = "Lost"
+)
+
+// StatusTransition is an allowed move of a record from one status to another
+type StatusTransition struct {
+	ObjectType string `json:"objectType"`
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+}
+
+// defaultTransitions are seeded by the first migration. A package can be lost
+// in transit or after its delivery.
+var defaultTransitions = []StatusTransition{
+	{objectAssembly, lifecycleStart, AssemblyCreated},
+	{objectAssembly, AssemblyCreated, AssemblyInAssembly},
+	{objectAssembly, AssemblyInAssembly, AssemblyQAPassed},
+	{objectAssembly, AssemblyInAssembly, AssemblyQAFailed},
+	{objectAssembly, AssemblyQAFailed, AssemblyInAssembly},
+	{objectAssembly, AssemblyQAPassed, AssemblyPackaged},
+	{objectAssembly, AssemblyPackaged, AssemblyShipped},
+	{objectAssembly, AssemblyShipped, AssemblyReturned},
+	{objectPackage, lifecycleStart, PackagePacked},
+	{objectPackage, PackagePacked, PackageReadyToShip},
+	{objectPackage, PackageReadyToShip, PackageInTransit},
+	{objectPackage, PackageInTransit, PackageDelivered},
+	{objectPackage, PackageInTransit, PackageLost},
+	{objectPackage, PackageDelivered, PackageReturned},
+	{objectPackage, PackageDelivered, PackageLost},
+}
+
+// seedTransitions stores the default lifecycle, keeping the transitions
+// already edited by the admins
+func seedTransitions(stub shim.ChaincodeStubInterface) error {
+	for _, transition := range defaultTransitions {
+		_, err := insertRecord(stub, keyTransition, transitionKeys(transition), transition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedTransitionsOf returns a migration storing the given default transitions,
+// for those added to the lifecycle after it was first seeded
+func seedTransitionsOf(transitions ...StatusTransition) func(stub shim.ChaincodeStubInterface) error {
+	return func(stub shim.ChaincodeStubInterface) error {
+		for _, transition := range transitions {
+			_, err := insertRecord(stub, keyTransition, transitionKeys(transition), transition)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// transitionKeys returns the key attributes of a StatusTransition
+func transitionKeys(transition StatusTransition) []string {
+	return []string{transition.ObjectType, transition.FromStatus, transition.ToStatus}
+}
+
+// getTransitions returns the allowed transitions of an object type, all of them
+// when fromStatus is empty
+func getTransitions(stub shim.ChaincodeStubInterface, objectType string, fromStatus string) ([]StatusTransition, error) {
+	keys := []string{objectType}
+	if fromStatus != "" {
+		keys = append(keys, fromStatus)
+	}
+
+	transitions := []StatusTransition{}
+	err := scanRecords(stub, keyTransition, keys, func(keys []string, value []byte) error {
+		transitions = append(transitions, StatusTransition{
+			ObjectType: keys[0],
+			FromStatus: keys[1],
+			ToStatus:   keys[2],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve lifecycle of %s", objectType)
+	}
+	return transitions, nil
+}
+
+// checkTransition fails unless the lifecycle of objectType allows moving from
+// fromStatus to toStatus. An empty fromStatus checks the status a new record is
+// created with. Keeping the current status is always allowed.
+func checkTransition(stub shim.ChaincodeStubInterface, objectType string, fromStatus string, toStatus string) error {
+	if fromStatus != "" && fromStatus == toStatus {
+		return nil
+	}
+	if fromStatus == "" {
+		fromStatus = lifecycleStart
+	}
+
+	transitions, err := getTransitions(stub, objectType, fromStatus)
+	if err != nil {
+		return err
+	}
+
+	allowed := []string{}
+	for _, transition := range transitions {
+		if transition.ToStatus == toStatus {
+			return nil
+		}
+		allowed = append(allowed, transition.ToStatus)
+	}
+
+	if fromStatus == lifecycleStart {
+		return fmt.Errorf("Illegal initial %s status %q. Allowed: [%s].", objectType, toStatus, strings.Join(allowed, ", "))
+	}
+	return fmt.Errorf("Illegal %s status transition from %q to %q. Allowed: [%s].", objectType, fromStatus, toStatus, strings.Join(allowed, ", "))
+}
+
+// newTransition validates the objectType, fromStatus, toStatus arguments of
+// the lifecycle admin functions
+func newTransition(objectType string, fromStatus string, toStatus string) (StatusTransition, error) {
+	transition := StatusTransition{ObjectType: objectType, FromStatus: fromStatus, ToStatus: toStatus}
+	if transition.ObjectType != objectAssembly && transition.ObjectType != objectPackage {
+		return transition, fmt.Errorf("Unknown lifecycle object type %q.", transition.ObjectType)
+	}
+	if transition.FromStatus == "" || transition.ToStatus == "" || transition.ToStatus == lifecycleStart {
+		return transition, fmt.Errorf("Statuses must not be empty and %q is only allowed as fromStatus, for the initial statuses.", lifecycleStart)
+	}
+	return transition, nil
+}
+
+//Admin API to allow a status transition
+func (t *TnT) AddStatusTransition(ctx contractapi.TransactionContextInterface, objectType string, fromStatus string, toStatus string) error {
+	transition, err := newTransition(objectType, fromStatus, toStatus)
+	if err != nil {
+		return err
+	}
+
+	ok, err := insertRecord(ctx.GetStub(), keyTransition, transitionKeys(transition), transition)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("Transition already exists.")
+	}
+	return nil
+}
+
+//Admin API to disallow a status transition
+func (t *TnT) RemoveStatusTransition(ctx contractapi.TransactionContextInterface, objectType string, fromStatus string, toStatus string) error {
+	transition, err := newTransition(objectType, fromStatus, toStatus)
+	if err != nil {
+		return err
+	}
+
+	return deleteRecord(ctx.GetStub(), keyTransition, transitionKeys(transition))
+}
+
+//get the allowed status transitions of an object type
+func (t *TnT) GetStatusTransitions(ctx contractapi.TransactionContextInterface, objectType string) ([]StatusTransition, error) {
+	return getTransitions(ctx.GetStub(), objectType, "")
+}
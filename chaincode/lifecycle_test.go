@@ -0,0 +1,167 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestStatusTransitionAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		fn         func(l *testLedger) error
+		wantErr    string
+		assemblies int
+		packages   int
+	}{
+		{
+			name: "add",
+			fn: func(l *testLedger) error {
+				return l.tnt.AddStatusTransition(l.admin(), objectAssembly, AssemblyQAFailed, AssemblyRecalled)
+			},
+			assemblies: 9,
+			packages:   7,
+		},
+		{
+			name: "remove",
+			fn: func(l *testLedger) error {
+				return l.tnt.RemoveStatusTransition(l.admin(), objectPackage, PackageInTransit, PackageLost)
+			},
+			assemblies: 8,
+			packages:   6,
+		},
+		{
+			name: "add twice",
+			fn: func(l *testLedger) error {
+				return l.tnt.AddStatusTransition(l.admin(), objectAssembly, AssemblyCreated, AssemblyInAssembly)
+			},
+			wantErr:    "Transition already exists.",
+			assemblies: 8,
+			packages:   7,
+		},
+		{
+			name:       "unknown object type",
+			fn:         func(l *testLedger) error { return l.tnt.AddStatusTransition(l.admin(), "Widget", "A", "B") },
+			wantErr:    `Unknown lifecycle object type "Widget".`,
+			assemblies: 8,
+			packages:   7,
+		},
+		{
+			name: "empty status",
+			fn: func(l *testLedger) error {
+				return l.tnt.AddStatusTransition(l.admin(), objectPackage, PackagePacked, "")
+			},
+			wantErr:    `Statuses must not be empty and "*" is only allowed as fromStatus, for the initial statuses.`,
+			assemblies: 8,
+			packages:   7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+
+			err := l.invoke(func() error { return test.fn(l) })
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			assemblies, err := l.tnt.GetStatusTransitions(l.admin(), objectAssembly)
+			if err != nil {
+				t.Fatalf("GetStatusTransitions: %s", err)
+			}
+			packages, err := l.tnt.GetStatusTransitions(l.admin(), objectPackage)
+			if err != nil {
+				t.Fatalf("GetStatusTransitions: %s", err)
+			}
+			if len(assemblies) != test.assemblies || len(packages) != test.packages {
+				t.Errorf("expected %d assembly and %d package transitions, got %d and %d", test.assemblies, test.packages, len(assemblies), len(packages))
+			}
+		})
+	}
+}
+
+func TestAddedTransitionIsAllowed(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+
+	err := l.invoke(func() error {
+		return l.tnt.PatchAssembly(ctx, assemblyId, `{"assemblyStatus": "QA-Passed"}`)
+	})
+	expectError(t, err, `Illegal AssemblyLine status transition from "Created" to "QA-Passed". Allowed: [InAssembly].`)
+
+	l.mustInvoke("AddStatusTransition", func() error {
+		return l.tnt.AddStatusTransition(l.admin(), objectAssembly, AssemblyCreated, AssemblyQAPassed)
+	})
+	l.moveAssembly(ctx, assemblyId, AssemblyQAPassed)
+}
+
+func TestTransitionPackage(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+	carrier := l.caller("driver1", "", RoleLogistics)
+	caseId := l.packedCase(packer, "PLANT1")
+
+	steps := []struct {
+		status  string
+		wantErr string
+	}{
+		{PackageReadyToShip, ""},
+		{PackageDelivered, `Illegal PackageLine status transition from "ReadyToShip" to "Delivered". Allowed: [InTransit].`},
+		{PackageInTransit, ""},
+		{PackageDelivered, ""},
+		{PackageLost, ""},
+	}
+	for _, step := range steps {
+		err := l.invoke(func() error {
+			return l.tnt.TransitionPackage(carrier, caseId, step.status, "note "+step.status, "Dock 4")
+		})
+		if step.wantErr != "" {
+			expectError(t, err, step.wantErr)
+		} else if err != nil {
+			t.Fatalf("TransitionPackage to %s: %s", step.status, err)
+		}
+	}
+
+	milestones, err := l.tnt.GetPackageMilestones(carrier, caseId)
+	if err != nil {
+		t.Fatalf("GetPackageMilestones: %s", err)
+	}
+	expected := []string{PackageReadyToShip, PackageInTransit, PackageDelivered, PackageLost}
+	if len(milestones) != len(expected) {
+		t.Fatalf("expected %d milestones, got %d", len(expected), len(milestones))
+	}
+	for i, milestone := range milestones {
+		if milestone.Sequence != i+1 || milestone.Status != expected[i] || milestone.Location != "Dock 4" || milestone.RecordedBy != "driver1@PlantMSP" {
+			t.Errorf("unexpected milestone %d: %+v", i+1, milestone)
+		}
+	}
+	if status := l.packageLine(caseId).PackageStatus; status != PackageLost {
+		t.Errorf("expected package Lost, got %s", status)
+	}
+
+	err = l.invoke(func() error {
+		return l.tnt.TransitionPackage(carrier, "CASE-NONE", PackageReadyToShip, "", "")
+	})
+	expectError(t, err, "Package CASE-NONE not found.")
+}
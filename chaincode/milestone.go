@@ -0,0 +1,131 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PackageMilestone is a shipping milestone reached by a case
+type PackageMilestone struct {
+	CaseId     string `json:"caseId"`
+	Sequence   int    `json:"sequence"`
+	Status     string `json:"status"`
+	Note       string `json:"note"`
+	Location   string `json:"location"`
+	RecordedOn string `json:"recordedOn"`
+	RecordedBy string `json:"recordedBy"`
+	TxId       string `json:"txId"`
+}
+
+// getMilestones returns the milestones of a case, oldest first. Milestones are
+// keyed by case id and a zero padded sequence so they read back in order.
+func getMilestones(stub shim.ChaincodeStubInterface, caseId string) ([]*PackageMilestone, error) {
+	milestones := []*PackageMilestone{}
+	err := scanRecords(stub, keyMilestone, []string{caseId}, func(keys []string, value []byte) error {
+		milestone := new(PackageMilestone)
+		err := json.Unmarshal(value, milestone)
+		if err != nil {
+			return fmt.Errorf("Corrupt milestone of case %s: %s", caseId, err)
+		}
+		milestones = append(milestones, milestone)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return milestones, nil
+}
+
+// recordMilestone appends a milestone to the milestones of its case
+func recordMilestone(stub shim.ChaincodeStubInterface, milestone *PackageMilestone) error {
+	milestones, err := getMilestones(stub, milestone.CaseId)
+	if err != nil {
+		return err
+	}
+	milestone.Sequence = len(milestones) + 1
+
+	ok, err := insertRecord(stub, keyMilestone, []string{milestone.CaseId, fmt.Sprintf("%08d", milestone.Sequence)}, milestone)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Milestone %d of case %s already exists.", milestone.Sequence, milestone.CaseId)
+	}
+	return nil
+}
+
+//API to move a package to its next status, recording a shipping milestone.
+//The note and location are optional.
+func (t *TnT) TransitionPackage(ctx contractapi.TransactionContextInterface, caseId string, packageStatus string, note string, location string) error {
+	stub := ctx.GetStub()
+
+	_previous, err := getPackage(stub, caseId)
+	if err != nil {
+		return err
+	}
+	if _previous == nil {
+		return fmt.Errorf("Package %s not found.", caseId)
+	}
+
+	err = checkTransition(stub, objectPackage, _previous.PackageStatus, packageStatus)
+	if err != nil {
+		return err
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+
+	_package := *_previous
+	_package.PackageStatus = packageStatus
+	_package.PackageLastUpdatedOn = _time.Format("2006-01-02")
+	_package.PackageLastUpdatedBy = _caller
+
+	err = replacePackage(stub, _previous, &_package)
+	if err != nil {
+		return err
+	}
+
+	return recordMilestone(stub, &PackageMilestone{
+		CaseId:     caseId,
+		Status:     packageStatus,
+		Note:       note,
+		Location:   location,
+		RecordedOn: _time.Format(time.RFC3339),
+		RecordedBy: _package.PackageLastUpdatedBy,
+		TxId:       stub.GetTxID(),
+	})
+}
+
+//get the shipping milestones of a Package
+func (t *TnT) GetPackageMilestones(ctx contractapi.TransactionContextInterface, caseId string) ([]*PackageMilestone, error) {
+	return getMilestones(ctx.GetStub(), caseId)
+}
@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testStub is the in-memory world state the tests run the chaincode on. Like
+// a peer, and unlike shimtest.MockStub alone, it keeps the writes of a
+// transaction apart until the transaction succeeds: reads only see what the
+// previous transactions committed and a failed transaction writes nothing.
+type testStub struct {
+	*shimtest.MockStub
+	writes  map[string][]byte
+	deletes map[string]bool
+	// function is the name of the transaction function being invoked
+	function string
+}
+
+func newTestStub() *testStub {
+	return &testStub{MockStub: shimtest.NewMockStub("tnt", nil)}
+}
+
+// begin starts a transaction
+func (s *testStub) begin(txId string) {
+	s.writes = map[string][]byte{}
+	s.deletes = map[string]bool{}
+	s.MockTransactionStart(txId)
+}
+
+// commit applies the writes of the transaction to the world state and ends it
+func (s *testStub) commit() error {
+	for key, value := range s.writes {
+		if err := s.MockStub.PutState(key, value); err != nil {
+			return err
+		}
+	}
+	for key := range s.deletes {
+		if err := s.MockStub.DelState(key); err != nil {
+			return err
+		}
+	}
+	s.rollback()
+	return nil
+}
+
+// rollback drops the writes of the transaction and ends it
+func (s *testStub) rollback() {
+	s.writes = nil
+	s.deletes = nil
+	s.MockTransactionEnd(s.TxID)
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	if s.writes == nil {
+		return errors.New("PutState outside of a transaction")
+	}
+	if key == "" {
+		return errors.New("PutState with an empty key")
+	}
+	delete(s.deletes, key)
+	s.writes[key] = value
+	return nil
+}
+
+func (s *testStub) DelState(key string) error {
+	if s.writes == nil {
+		return errors.New("DelState outside of a transaction")
+	}
+	delete(s.writes, key)
+	s.deletes[key] = true
+	return nil
+}
+
+// GetFunctionAndParameters returns the function set by the test. The tests
+// pass the parameters to the transaction functions directly.
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, []string{}
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns the page of pageSize
+// keys starting at bookmark, and the first key of the next page as bookmark
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iterator, err := s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iterator.Close()
+
+	page := &testIterator{}
+	next := ""
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			next = kv.Key
+			break
+		}
+		page.kvs = append(page.kvs, kv)
+	}
+	return page, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(page.kvs)), Bookmark: next}, nil
+}
+
+// testIterator iterates over a page of query results
+type testIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *testIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *testIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("No more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *testIterator) Close() error {
+	return nil
+}
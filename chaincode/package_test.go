@@ -0,0 +1,296 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCreatePackage(t *testing.T) {
+	tests := []struct {
+		name string
+		// args returns the holder and charger to pack, given a packable
+		// holder and charger and a charger still being assembled
+		args    func(holderId, chargerId, newChargerId string) (string, string)
+		status  string
+		wantErr func(holderId, chargerId, newChargerId string) string
+	}{
+		{
+			name:   "packed",
+			args:   func(h, c, n string) (string, string) { return h, c },
+			status: PackagePacked,
+		},
+		{
+			name:    "same assembly",
+			args:    func(h, c, n string) (string, string) { return h, h },
+			status:  PackagePacked,
+			wantErr: func(h, c, n string) string { return "Holder and charger assembly must be different." },
+		},
+		{
+			name:    "unknown holder",
+			args:    func(h, c, n string) (string, string) { return "ASM-NONE", c },
+			status:  PackagePacked,
+			wantErr: func(h, c, n string) string { return "Holder assembly ASM-NONE not found." },
+		},
+		{
+			name:    "swapped device types",
+			args:    func(h, c, n string) (string, string) { return c, h },
+			status:  PackagePacked,
+			wantErr: func(h, c, n string) string { return "Assembly " + c + " is a Charger, expecting a Holder." },
+		},
+		{
+			name:   "charger not through QA",
+			args:   func(h, c, n string) (string, string) { return h, n },
+			status: PackagePacked,
+			wantErr: func(h, c, n string) string {
+				return "Assembly " + n + ` cannot be packed: Illegal AssemblyLine status transition from "Created" to "Packaged". Allowed: [InAssembly].`
+			},
+		},
+		{
+			name:   "illegal initial status",
+			args:   func(h, c, n string) (string, string) { return h, c },
+			status: PackageDelivered,
+			wantErr: func(h, c, n string) string {
+				return `Illegal initial PackageLine status "Delivered". Allowed: [Packed].`
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			ctx := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+			holderId := l.packableAssembly(ctx, "SN-H", DeviceTypeHolder, "PLANT1")
+			chargerId := l.packableAssembly(ctx, "SN-C", DeviceTypeCharger, "PLANT1")
+			newChargerId := l.createAssembly(ctx, "SN-N", DeviceTypeCharger, "PLANT1")
+			holder, charger := test.args(holderId, chargerId, newChargerId)
+
+			var result *PackageCreateResult
+			err := l.invoke(func() (err error) {
+				result, err = l.tnt.CreatePackage(ctx, holder, charger, test.status, "2024-01-02", "1 Main St", "P1")
+				return err
+			})
+			if test.wantErr != nil {
+				expectError(t, err, test.wantErr(holderId, chargerId, newChargerId))
+				if l.assembly(holderId).AssemblyStatus != AssemblyQAPassed {
+					t.Errorf("failed create packed holder %s", holderId)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreatePackage: %s", err)
+			}
+
+			if !strings.HasPrefix(result.CaseId, "CASE-P1-0000000001-") {
+				t.Errorf("unexpected case id %s", result.CaseId)
+			}
+			for _, assemblyId := range []string{holderId, chargerId} {
+				assembly := l.assembly(assemblyId)
+				if assembly.AssemblyStatus != AssemblyPackaged || assembly.CaseId != result.CaseId {
+					t.Errorf("assembly %s is %s in case %q", assemblyId, assembly.AssemblyStatus, assembly.CaseId)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePackageTwice(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+	caseId := l.packedCase(ctx, "PLANT1")
+	_package := l.packageLine(caseId)
+	chargerId := l.packableAssembly(ctx, "SN-C2", DeviceTypeCharger, "PLANT1")
+
+	// The holder is already packed, and no longer QA-Passed
+	err := l.invoke(func() error {
+		_, err := l.tnt.CreatePackage(ctx, _package.HolderAssemblyId, chargerId, PackagePacked, "", "", "")
+		return err
+	})
+	expectError(t, err, "Assembly "+_package.HolderAssemblyId+" is already packed in case "+caseId+".")
+}
+
+func TestCreatePackageFromJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		wantErr  string
+	}{
+		{"valid", `{"holderAssemblyId": "%h", "chargerAssemblyId": "%c", "packageStatus": "Packed", "packagingDate": "2024-01-02", "packingLine": "P9"}`, ""},
+		{"bad date", `{"holderAssemblyId": "%h", "chargerAssemblyId": "%c", "packageStatus": "Packed", "packagingDate": "02/01/2024"}`, "Invalid package: packagingDate must be a date in YYYY-MM-DD format."},
+		{"missing assemblies", `{"packageStatus": "Packed"}`, "Invalid package: holderAssemblyId is missing; chargerAssemblyId is missing."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			ctx := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+			holderId := l.packableAssembly(ctx, "SN-H", DeviceTypeHolder, "PLANT1")
+			chargerId := l.packableAssembly(ctx, "SN-C", DeviceTypeCharger, "PLANT1")
+			document := strings.NewReplacer("%h", holderId, "%c", chargerId).Replace(test.document)
+
+			var result *PackageCreateResult
+			err := l.invoke(func() (err error) {
+				result, err = l.tnt.CreatePackageFromJSON(ctx, document)
+				return err
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreatePackageFromJSON: %s", err)
+			}
+			if !strings.HasPrefix(result.CaseId, "CASE-P9-") {
+				t.Errorf("unexpected case id %s", result.CaseId)
+			}
+			if _package := l.packageLine(result.CaseId); _package.PackagingDate != "2024-01-02" {
+				t.Errorf("unexpected package %+v", _package)
+			}
+		})
+	}
+}
+
+func TestPatchPackage(t *testing.T) {
+	tests := []struct {
+		name    string
+		caseId  string
+		patch   string
+		wantErr string
+	}{
+		{"status and address", "", `{"packageStatus": "ReadyToShip", "shippingToAddress": "2 Side St"}`, ""},
+		{"assemblies are fixed", "", `{"holderAssemblyId": "ASM-OTHER"}`, "Invalid package patch: holderAssemblyId cannot be changed."},
+		{"illegal transition", "", `{"packageStatus": "Delivered"}`, `Illegal PackageLine status transition from "Packed" to "Delivered". Allowed: [ReadyToShip].`},
+		{"unknown case", "CASE-NONE", `{"packageStatus": "ReadyToShip"}`, "Package CASE-NONE not found."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			ctx := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+			caseId := l.packedCase(ctx, "PLANT1")
+			if test.caseId != "" {
+				caseId = test.caseId
+			}
+
+			err := l.invoke(func() error {
+				return l.tnt.PatchPackage(l.caller("packer2", "PLANT1", RolePacker), caseId, test.patch)
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("PatchPackage: %s", err)
+			}
+
+			_package := l.packageLine(caseId)
+			if _package.PackageStatus != PackageReadyToShip || _package.ShippingToAddress != "2 Side St" || _package.PackagingDate != "2024-01-02" {
+				t.Errorf("unexpected package %+v", _package)
+			}
+			if _package.PackageCreatedBy != "packer1@PlantMSP" || _package.PackageLastUpdatedBy != "packer2@PlantMSP" {
+				t.Errorf("expected created by packer1 and updated by packer2, got %s and %s", _package.PackageCreatedBy, _package.PackageLastUpdatedBy)
+			}
+		})
+	}
+}
+
+func TestPackageQueries(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+	caseId := l.packedCase(ctx, "PLANT1")
+	_package := l.packageLine(caseId)
+	looseId := l.createAssembly(ctx, "SN-L", DeviceTypeHolder, "PLANT1")
+
+	packages, err := l.tnt.GetPackageByID(ctx, caseId)
+	if err != nil || len(packages) != 1 || packages[0].CaseId != caseId {
+		t.Errorf("GetPackageByID: %v %v", packages, err)
+	}
+	_, err = l.tnt.GetPackageByID(ctx, "CASE-NONE")
+	expectError(t, err, "Package CASE-NONE not found.")
+
+	packages, err = l.tnt.GetAllPackage(ctx)
+	if err != nil || len(packages) != 1 {
+		t.Errorf("GetAllPackage: %v %v", packages, err)
+	}
+
+	page, err := l.tnt.GetAllPackagePage(ctx, 0, "")
+	if err != nil || page.Count != 1 || page.NextBookmark != "" {
+		t.Errorf("GetAllPackagePage: %+v %v", page, err)
+	}
+	_, err = l.tnt.GetAllPackagePage(ctx, -5, "")
+	expectError(t, err, "Invalid page size -5. Expecting 1 to 1000.")
+
+	packed, err := l.tnt.GetPackageByAssemblyID(ctx, _package.ChargerAssemblyId)
+	if err != nil || packed.CaseId != caseId {
+		t.Errorf("GetPackageByAssemblyID: %+v %v", packed, err)
+	}
+	_, err = l.tnt.GetPackageByAssemblyID(ctx, looseId)
+	expectError(t, err, "Assembly "+looseId+" is not packed in any case.")
+	_, err = l.tnt.GetPackageByAssemblyID(l.caller("op2", "PLANT2", RoleAssembler), looseId)
+	expectError(t, err, `Permission denied. Caller of plant "PLANT2" cannot read assemblies of plant "PLANT1".`)
+
+	history, err := l.tnt.GetPackageHistory(ctx, caseId)
+	if err != nil || len(history) != 1 || history[0].Record.CaseId != caseId {
+		t.Errorf("GetPackageHistory: %v %v", history, err)
+	}
+}
+
+func TestPackagedOnlyByPacking(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RolePacker+","+RoleAssembler)
+	assemblyId := l.packableAssembly(packer, "SN-1", DeviceTypeHolder, "PLANT1")
+
+	statuses, err := packedStatuses(l.stub)
+	if err != nil {
+		t.Fatalf("packedStatuses: %s", err)
+	}
+	if fmt.Sprint(statuses) != "[Packaged Shipped Returned]" {
+		t.Errorf("unexpected packed statuses %v", statuses)
+	}
+
+	const notPacked = "An assembly that is not packed in a case cannot be Packaged. Assemblies are packed by CreatePackage."
+	err = l.invoke(func() error {
+		return l.tnt.PatchAssembly(packer, assemblyId, `{"assemblyStatus": "Packaged"}`)
+	})
+	expectError(t, err, notPacked)
+	err = l.invoke(func() error {
+		return l.tnt.UpdateAssemblyByID(packer, assemblyId, "SN-1", DeviceTypeHolder, "FIL-1", "LED-1", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", "PLANT1", AssemblyPackaged, "")
+	})
+	expectError(t, err, notPacked)
+
+	l.mustInvoke("AddStatusTransition", func() error {
+		return l.tnt.AddStatusTransition(l.admin(), objectAssembly, lifecycleStart, AssemblyPackaged)
+	})
+	err = l.invoke(func() error {
+		_, err := l.tnt.CreateAssembly(packer, "SN-2", DeviceTypeHolder, "", "", "", "", "", "", "", "PLANT1", AssemblyPackaged, "L1")
+		return err
+	})
+	expectError(t, err, notPacked)
+
+	// The assemblies of a case move on through their lifecycle
+	caseId := l.packedCase(packer, "PLANT1")
+	holderId := l.packageLine(caseId).HolderAssemblyId
+	l.moveAssembly(packer, holderId, AssemblyShipped)
+	if status := l.assembly(holderId).AssemblyStatus; status != AssemblyShipped {
+		t.Errorf("expected the packed assembly Shipped, got %s", status)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Device types of the assemblies packed together in a case, seeded in the
+// device type catalog
+const (
+	DeviceTypeHolder  = "Holder"
+	DeviceTypeCharger = "Charger"
+)
+
+// getPackableAssembly reads an assembly and checks that it can be packed in a
+// case in the given packaging role: it must exist, be of a device type of the
+// catalog packed in that role, be in a status from which the lifecycle allows
+// Packaged and not be in a case yet
+func getPackableAssembly(ctx contractapi.TransactionContextInterface, assemblyId string, packagingRole string) (*AssemblyLine, error) {
+	stub := ctx.GetStub()
+	assembly, err := getAssembly(stub, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if assembly == nil {
+		return nil, fmt.Errorf("%s assembly %s not found.", upperFirst(packagingRole), assemblyId)
+	}
+	err = checkPlantWrite(ctx, assembly.ManufacturingPlant)
+	if err != nil {
+		return nil, err
+	}
+	deviceType, _, err := findDeviceType(stub, assembly.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if deviceType == nil {
+		return nil, fmt.Errorf("Assembly %s is a %s, which is not in the device type catalog.", assemblyId, assembly.DeviceType)
+	}
+	if deviceType.PackagingRole != packagingRole {
+		return nil, fmt.Errorf("Assembly %s is a %s, expecting a %s.", assemblyId, assembly.DeviceType, upperFirst(packagingRole))
+	}
+	if assembly.CaseId != "" {
+		return nil, fmt.Errorf("Assembly %s is already packed in case %s.", assemblyId, assembly.CaseId)
+	}
+	err = checkNotRecalled(stub, assembly)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTransition(stub, objectAssembly, assembly.AssemblyStatus, AssemblyPackaged)
+	if err != nil {
+		return nil, fmt.Errorf("Assembly %s cannot be packed: %s", assemblyId, err)
+	}
+	return assembly, nil
+}
+
+// reachableStatuses returns the statuses the transitions reach from status,
+// never going through avoid
+func reachableStatuses(transitions []StatusTransition, status string, avoid string) map[string]bool {
+	seen := map[string]bool{status: true}
+	queue := []string{status}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for _, transition := range transitions {
+			if transition.FromStatus == from && transition.ToStatus != avoid && !seen[transition.ToStatus] {
+				seen[transition.ToStatus] = true
+				queue = append(queue, transition.ToStatus)
+			}
+		}
+	}
+	return seen
+}
+
+// packedStatuses returns Packaged and the assembly statuses the lifecycle
+// only reaches through it, those of the assemblies in a case
+func packedStatuses(stub shim.ChaincodeStubInterface) ([]string, error) {
+	transitions, err := getTransitions(stub, objectAssembly, "")
+	if err != nil {
+		return nil, err
+	}
+
+	packed := reachableStatuses(transitions, AssemblyPackaged, "")
+	unpacked := reachableStatuses(transitions, lifecycleStart, AssemblyPackaged)
+	statuses := []string{AssemblyPackaged}
+	for _, transition := range transitions {
+		status := transition.ToStatus
+		if packed[status] && !unpacked[status] && !stringsContain(statuses, status) {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// checkUnpackedStatus fails when an assembly that is not in a case moves to
+// Packaged or a status after it. Only packing an assembly in a case moves it
+// there. previous is nil for a new assembly.
+func checkUnpackedStatus(stub shim.ChaincodeStubInterface, previous *AssemblyLine, current *AssemblyLine) error {
+	if previous != nil && (previous.CaseId != "" || previous.AssemblyStatus == current.AssemblyStatus) {
+		return nil
+	}
+	statuses, err := packedStatuses(stub)
+	if err != nil {
+		return err
+	}
+	if stringsContain(statuses, current.AssemblyStatus) {
+		return fmt.Errorf("An assembly that is not packed in a case cannot be %s. Assemblies are packed by CreatePackage.", current.AssemblyStatus)
+	}
+	return nil
+}
+
+// markAssemblyPackaged moves an assembly checked by getPackableAssembly to
+// Packaged and links it to its case
+func markAssemblyPackaged(ctx contractapi.TransactionContextInterface, previous *AssemblyLine, caseId string) error {
+	stub := ctx.GetStub()
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+
+	assembly := *previous
+	assembly.AssemblyStatus = AssemblyPackaged
+	assembly.CaseId = caseId
+	assembly.AssemblyLastUpdatedOn = _time.Format("2006-01-02")
+	assembly.AssemblyLastUpdatedBy = caller
+
+	return replaceAssembly(stub, previous, &assembly)
+}
+
+// getCaseIdByAssembly returns the id of the case containing an assembly, or an
+// empty string when the assembly is not packed. Each packed assembly is indexed
+// to the case that contains it.
+func getCaseIdByAssembly(stub shim.ChaincodeStubInterface, assemblyId string) (string, error) {
+	caseId := ""
+	_, err := getRecord(stub, keyPackageByAssembly, []string{assemblyId}, &caseId)
+	if err != nil {
+		return "", err
+	}
+	return caseId, nil
+}
+
+// indexPackageAssemblies indexes the assemblies of a new case to it. An
+// assembly already indexed to another case is rejected.
+func indexPackageAssemblies(stub shim.ChaincodeStubInterface, _package *PackageLine) error {
+	for _, assemblyId := range []string{_package.HolderAssemblyId, _package.ChargerAssemblyId} {
+		if assemblyId == "" {
+			continue
+		}
+		caseId, err := getCaseIdByAssembly(stub, assemblyId)
+		if err != nil {
+			return err
+		}
+		if caseId == _package.CaseId {
+			continue
+		}
+		if caseId != "" {
+			return fmt.Errorf("Assembly %s is already packed in case %s.", assemblyId, caseId)
+		}
+
+		err = putRecord(stub, keyPackageByAssembly, []string{assemblyId}, _package.CaseId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//get the Package containing an Assembly
+func (t *TnT) GetPackageByAssemblyID(ctx contractapi.TransactionContextInterface, assemblyId string) (*PackageLine, error) {
+	stub := ctx.GetStub()
+
+	_assembly, err := getAssembly(stub, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if _assembly != nil {
+		err = checkPlantRead(ctx, _assembly.ManufacturingPlant)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_caseId, err := getCaseIdByAssembly(stub, assemblyId)
+	if err != nil {
+		return nil, err
+	}
+	if _caseId == "" {
+		return nil, fmt.Errorf("Assembly %s is not packed in any case.", assemblyId)
+	}
+
+	_package, err := getPackage(stub, _caseId)
+	if err != nil {
+		return nil, err
+	}
+	if _package == nil {
+		return nil, fmt.Errorf("Case %s of assembly %s not found.", _caseId, assemblyId)
+	}
+
+	return _package, nil
+}
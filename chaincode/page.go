@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Page sizes of the listing queries
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// AssemblyPage is one page of a listing query of assemblies. NextBookmark is
+// passed back to read the following page and is empty on the last one.
+type AssemblyPage struct {
+	Items        []*AssemblyLine `json:"items"`
+	NextBookmark string          `json:"nextBookmark"`
+	Count        int             `json:"count"`
+}
+
+// PackagePage is one page of a listing query of packages
+type PackagePage struct {
+	Items        []*PackageLine `json:"items"`
+	NextBookmark string         `json:"nextBookmark"`
+	Count        int            `json:"count"`
+}
+
+// checkPageSize validates the page size of a listing query. A page size of 0
+// selects the default.
+func checkPageSize(pageSize int32) (int32, error) {
+	if pageSize == 0 {
+		return defaultPageSize, nil
+	}
+	if pageSize < 1 || pageSize > maxPageSize {
+		return 0, fmt.Errorf("Invalid page size %d. Expecting 1 to %d.", pageSize, maxPageSize)
+	}
+	return pageSize, nil
+}
@@ -0,0 +1,408 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// stringsContain reports whether list contains value
+func stringsContain(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AffectedCase is a case containing assemblies built with a given batch
+type AffectedCase struct {
+	CaseId            string   `json:"caseId"`
+	PackageStatus     string   `json:"packageStatus"`
+	ShippingToAddress string   `json:"shippingToAddress"`
+	AssemblyIds       []string `json:"assemblyIds"`
+}
+
+// BatchImpact lists every assembly built with a component batch and every case
+// those assemblies went into
+type BatchImpact struct {
+	ComponentType string          `json:"componentType"`
+	BatchId       string          `json:"batchId"`
+	Assemblies    []*AssemblyLine `json:"assemblies"`
+	Cases         []*AffectedCase `json:"cases"`
+}
+
+// getBatchImpact collects the assemblies built with a batch and their cases,
+// leaving out the assemblies of plants the caller may not read
+func getBatchImpact(ctx contractapi.TransactionContextInterface, componentType string, batchId string) (*BatchImpact, error) {
+	stub := ctx.GetStub()
+	assemblyIds, err := getAssemblyIdsByBatch(stub, componentType, batchId)
+	if err != nil {
+		return nil, err
+	}
+	scope := callerPlantScope(ctx)
+
+	impact := &BatchImpact{
+		ComponentType: componentType,
+		BatchId:       batchId,
+		Assemblies:    []*AssemblyLine{},
+		Cases:         []*AffectedCase{},
+	}
+	cases := map[string]*AffectedCase{}
+	for _, assemblyId := range assemblyIds {
+		assembly, err := getAssembly(stub, assemblyId)
+		if err != nil {
+			return nil, err
+		}
+		if assembly == nil || !scope.canRead(assembly.ManufacturingPlant) {
+			continue
+		}
+		impact.Assemblies = append(impact.Assemblies, assembly)
+
+		caseId, err := getCaseIdByAssembly(stub, assemblyId)
+		if err != nil {
+			return nil, err
+		}
+		if caseId == "" {
+			continue
+		}
+		if affected, ok := cases[caseId]; ok {
+			affected.AssemblyIds = append(affected.AssemblyIds, assemblyId)
+			continue
+		}
+		_package, err := getPackage(stub, caseId)
+		if err != nil {
+			return nil, err
+		}
+		if _package == nil {
+			continue
+		}
+		affected := &AffectedCase{
+			CaseId:            caseId,
+			PackageStatus:     _package.PackageStatus,
+			ShippingToAddress: _package.ShippingToAddress,
+			AssemblyIds:       []string{assemblyId},
+		}
+		cases[caseId] = affected
+		impact.Cases = append(impact.Cases, affected)
+	}
+	return impact, nil
+}
+
+//get every Assembly and Package affected by a component batch
+func (t *TnT) GetAffectedByBatch(ctx contractapi.TransactionContextInterface, componentType string, batchId string) (*BatchImpact, error) {
+	_componentType, err := getComponentType(ctx.GetStub(), componentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return getBatchImpact(ctx, _componentType, batchId)
+}
+
+// Statuses of a Recall
+const (
+	RecallOpen   = "Open"
+	RecallClosed = "Closed"
+)
+
+// Recall is a recall campaign of one or more batches of a component type
+type Recall struct {
+	RecallId      string   `json:"recallId"`
+	ComponentType string   `json:"componentType"`
+	BatchIds      []string `json:"batchIds"`
+	Reason        string   `json:"reason"`
+	Status        string   `json:"status"`
+	OpenedBy      string   `json:"openedBy"`
+	OpenedOn      string   `json:"openedOn"`
+	ClosedBy      string   `json:"closedBy"`
+	ClosedOn      string   `json:"closedOn"`
+	Resolution    string   `json:"resolution"`
+	AssemblyIds   []string `json:"assemblyIds"`
+}
+
+// RecallStatus is a recall with the current state of what it affects
+type RecallStatus struct {
+	Recall     *Recall         `json:"recall"`
+	Assemblies []*AssemblyLine `json:"assemblies"`
+	Cases      []*AffectedCase `json:"cases"`
+}
+
+// RecallOpenResult is the result of OpenRecall
+type RecallOpenResult struct {
+	RecallId string `json:"recallId"`
+}
+
+// getRecall reads a recall by its id, returning nil when it does not exist
+func getRecall(stub shim.ChaincodeStubInterface, recallId string) (*Recall, error) {
+	recall := new(Recall)
+	ok, err := getRecord(stub, keyRecall, []string{recallId}, recall)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return recall, nil
+}
+
+// getOpenRecallId returns the id of the open recall of a batch, or an empty
+// string when the batch is not recalled. The batches under an open recall are
+// indexed by component type and batch id.
+func getOpenRecallId(stub shim.ChaincodeStubInterface, componentType string, batchId string) (string, error) {
+	recallId := ""
+	_, err := getRecord(stub, keyRecalledBatch, []string{componentType, batchId}, &recallId)
+	if err != nil {
+		return "", err
+	}
+	return recallId, nil
+}
+
+// checkNotRecalled fails when an assembly is flagged as Recalled or is built
+// with a batch under an open recall, so it cannot be packed
+func checkNotRecalled(stub shim.ChaincodeStubInterface, assembly *AssemblyLine) error {
+	if assembly.AssemblyStatus == AssemblyRecalled {
+		return fmt.Errorf("Assembly %s is recalled.", assembly.AssemblyId)
+	}
+	for _, componentType := range sortedKeys(assembly.Components) {
+		batchId := assembly.Components[componentType]
+		recallId, err := getOpenRecallId(stub, componentType, batchId)
+		if err != nil {
+			return err
+		}
+		if recallId != "" {
+			return fmt.Errorf("Assembly %s is built with %s batch %s under recall %s.", assembly.AssemblyId, componentType, batchId, recallId)
+		}
+	}
+	return nil
+}
+
+//API to open a recall of component batches
+func (t *TnT) OpenRecall(ctx contractapi.TransactionContextInterface, componentType string, reason string, batchIds []string) (*RecallOpenResult, error) {
+	stub := ctx.GetStub()
+
+	_componentType, err := getComponentType(stub, componentType)
+	if err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		return nil, errors.New("A recall needs a reason.")
+	}
+	if len(batchIds) == 0 {
+		return nil, errors.New("A recall needs at least one batch id.")
+	}
+	_batchIds := []string{}
+	for _, batchId := range batchIds {
+		if batchId == "" {
+			return nil, errors.New("Batch ids must not be empty.")
+		}
+		if stringsContain(_batchIds, batchId) {
+			continue
+		}
+		_batchIds = append(_batchIds, batchId)
+
+		// Batches used by the legacy chaincode were never registered, they
+		// are known from the assemblies built with them
+		batch, err := getBatch(stub, _componentType, batchId)
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			assemblyIds, err := getAssemblyIdsByBatch(stub, _componentType, batchId)
+			if err != nil {
+				return nil, err
+			}
+			if len(assemblyIds) == 0 {
+				return nil, fmt.Errorf("Unknown %s batch %s.", _componentType, batchId)
+			}
+		}
+
+		recallId, err := getOpenRecallId(stub, _componentType, batchId)
+		if err != nil {
+			return nil, err
+		}
+		if recallId != "" {
+			return nil, fmt.Errorf("%s batch %s is already under recall %s.", _componentType, batchId, recallId)
+		}
+	}
+
+	_recallId, err := nextID(stub, recallSeqKey, recallIDPrefix)
+	if err != nil {
+		return nil, err
+	}
+	_time, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_recall := &Recall{
+		RecallId:      _recallId,
+		ComponentType: _componentType,
+		BatchIds:      _batchIds,
+		Reason:        reason,
+		Status:        RecallOpen,
+		OpenedBy:      _caller,
+		OpenedOn:      _time.Format(time.RFC3339),
+		AssemblyIds:   []string{},
+	}
+
+	// Flag every assembly built with the batches
+	flagged := map[string]bool{}
+	for _, batchId := range _batchIds {
+		assemblyIds, err := getAssemblyIdsByBatch(stub, _componentType, batchId)
+		if err != nil {
+			return nil, err
+		}
+		for _, assemblyId := range assemblyIds {
+			assembly, err := getAssembly(stub, assemblyId)
+			if err != nil {
+				return nil, err
+			}
+			if assembly == nil || flagged[assemblyId] {
+				continue
+			}
+			flagged[assemblyId] = true
+			_recall.AssemblyIds = append(_recall.AssemblyIds, assemblyId)
+			if assembly.AssemblyStatus == AssemblyRecalled {
+				continue
+			}
+
+			recalled := *assembly
+			recalled.AssemblyStatus = AssemblyRecalled
+			recalled.AssemblyLastUpdatedOn = _time.Format("2006-01-02")
+			recalled.AssemblyLastUpdatedBy = _recall.OpenedBy
+			err = replaceAssembly(stub, assembly, &recalled)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = putRecord(stub, keyRecalledBatch, []string{_componentType, batchId}, _recallId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ok, err := insertRecord(stub, keyRecall, []string{_recallId}, _recall)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Recall %s already exists.", _recallId)
+	}
+
+	return &RecallOpenResult{RecallId: _recallId}, nil
+}
+
+//API to close an open recall
+func (t *TnT) CloseRecall(ctx contractapi.TransactionContextInterface, recallId string, resolution string) error {
+	stub := ctx.GetStub()
+
+	_recall, err := getRecall(stub, recallId)
+	if err != nil {
+		return err
+	}
+	if _recall == nil {
+		return fmt.Errorf("Recall %s not found.", recallId)
+	}
+	if _recall.Status != RecallOpen {
+		return fmt.Errorf("Recall %s is already %s.", _recall.RecallId, _recall.Status)
+	}
+
+	_time, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+	_recall.Status = RecallClosed
+	_recall.ClosedBy = _caller
+	_recall.ClosedOn = _time.Format(time.RFC3339)
+	_recall.Resolution = resolution
+
+	// The batches can be packed again; flagged assemblies stay Recalled
+	for _, batchId := range _recall.BatchIds {
+		err = deleteRecord(stub, keyRecalledBatch, []string{_recall.ComponentType, batchId})
+		if err != nil {
+			return err
+		}
+	}
+
+	ok, err := replaceRecord(stub, keyRecall, []string{_recall.RecallId}, _recall)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Recall %s not found.", _recall.RecallId)
+	}
+	return nil
+}
+
+//get a Recall with the current status of its assemblies and cases
+func (t *TnT) GetRecallStatus(ctx contractapi.TransactionContextInterface, recallId string) (*RecallStatus, error) {
+	_recall, err := getRecall(ctx.GetStub(), recallId)
+	if err != nil {
+		return nil, err
+	}
+	if _recall == nil {
+		return nil, fmt.Errorf("Recall %s not found.", recallId)
+	}
+	status := &RecallStatus{
+		Recall:     _recall,
+		Assemblies: []*AssemblyLine{},
+		Cases:      []*AffectedCase{},
+	}
+	assemblies := map[string]bool{}
+	cases := map[string]*AffectedCase{}
+	for _, batchId := range _recall.BatchIds {
+		impact, err := getBatchImpact(ctx, _recall.ComponentType, batchId)
+		if err != nil {
+			return nil, err
+		}
+		for _, assembly := range impact.Assemblies {
+			if !assemblies[assembly.AssemblyId] {
+				assemblies[assembly.AssemblyId] = true
+				status.Assemblies = append(status.Assemblies, assembly)
+			}
+		}
+		for _, affected := range impact.Cases {
+			if merged, ok := cases[affected.CaseId]; ok {
+				for _, assemblyId := range affected.AssemblyIds {
+					if !stringsContain(merged.AssemblyIds, assemblyId) {
+						merged.AssemblyIds = append(merged.AssemblyIds, assemblyId)
+					}
+				}
+				continue
+			}
+			cases[affected.CaseId] = affected
+			status.Cases = append(status.Cases, affected)
+		}
+	}
+
+	return status, nil
+}
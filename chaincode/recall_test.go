@@ -0,0 +1,143 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestOpenRecallErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		componentType string
+		reason        string
+		batchIds      []string
+		wantErr       string
+	}{
+		{"unknown component", "glass", "cracks", []string{"GL-1"}, `Unknown component type "glass". Expecting one of [filament, led, circuitBoard, wire, casing, adaptor, stickPod].`},
+		{"no reason", "led", "", []string{"LED-1"}, "A recall needs a reason."},
+		{"no batch", "led", "flicker", []string{}, "A recall needs at least one batch id."},
+		{"empty batch", "led", "flicker", []string{"LED-1", ""}, "Batch ids must not be empty."},
+		{"unknown batch", "led", "flicker", []string{"LED-1", "LED-99"}, "Unknown led batch LED-99."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				_, err := l.tnt.OpenRecall(l.admin(), test.componentType, test.reason, test.batchIds)
+				return err
+			})
+			expectError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestRecall(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RoleAssembler+","+RolePacker)
+	caseId := l.packedCase(packer, "PLANT1")
+	_package := l.packageLine(caseId)
+	looseId := l.createAssembly(packer, "SN-L", DeviceTypeHolder, "PLANT1")
+
+	impact, err := l.tnt.GetAffectedByBatch(packer, "LED", "LED-1")
+	if err != nil {
+		t.Fatalf("GetAffectedByBatch: %s", err)
+	}
+	if impact.ComponentType != "led" || len(impact.Assemblies) != 3 || len(impact.Cases) != 1 || len(impact.Cases[0].AssemblyIds) != 2 {
+		t.Errorf("unexpected impact %+v", impact)
+	}
+
+	var opened *RecallOpenResult
+	l.mustInvoke("OpenRecall", func() (err error) {
+		opened, err = l.tnt.OpenRecall(l.admin(), "led", "flicker", []string{"LED-1"})
+		return err
+	})
+	for _, assemblyId := range []string{_package.HolderAssemblyId, _package.ChargerAssemblyId, looseId} {
+		if status := l.assembly(assemblyId).AssemblyStatus; status != AssemblyRecalled {
+			t.Errorf("assembly %s is %s, expected Recalled", assemblyId, status)
+		}
+	}
+
+	// A batch is under one open recall at a time
+	err = l.invoke(func() error {
+		_, err := l.tnt.OpenRecall(l.admin(), "led", "again", []string{"LED-1"})
+		return err
+	})
+	expectError(t, err, "led batch LED-1 is already under recall "+opened.RecallId+".")
+
+	// No assembly is built with the batch while it is recalled
+	err = l.invoke(func() error {
+		_, err := l.tnt.CreateAssembly(packer, "SN-H2", DeviceTypeHolder, "FIL-1", "LED-1", "", "", "", "", "", "PLANT1", AssemblyCreated, "L1")
+		return err
+	})
+	expectError(t, err, "led batch LED-1 is under recall "+opened.RecallId+".")
+
+	status, err := l.tnt.GetRecallStatus(l.admin(), opened.RecallId)
+	if err != nil {
+		t.Fatalf("GetRecallStatus: %s", err)
+	}
+	if status.Recall.Status != RecallOpen || len(status.Recall.AssemblyIds) != 3 || len(status.Assemblies) != 3 || len(status.Cases) != 1 {
+		t.Errorf("unexpected recall status %+v", status)
+	}
+
+	l.mustInvoke("CloseRecall", func() error { return l.tnt.CloseRecall(l.admin(), opened.RecallId, "supplier replaced") })
+	err = l.invoke(func() error { return l.tnt.CloseRecall(l.admin(), opened.RecallId, "again") })
+	expectError(t, err, "Recall "+opened.RecallId+" is already Closed.")
+	err = l.invoke(func() error { return l.tnt.CloseRecall(l.admin(), "RCL-NONE", "") })
+	expectError(t, err, "Recall RCL-NONE not found.")
+	_, err = l.tnt.GetRecallStatus(l.admin(), "RCL-NONE")
+	expectError(t, err, "Recall RCL-NONE not found.")
+
+	// Once closed, the batch can be used and packed again
+	holderId := l.packableAssembly(packer, "SN-H2", DeviceTypeHolder, "PLANT1")
+	chargerId := l.packableAssembly(packer, "SN-C2", DeviceTypeCharger, "PLANT1")
+	l.mustInvoke("CreatePackage", func() error {
+		_, err := l.tnt.CreatePackage(packer, holderId, chargerId, PackagePacked, "", "", "")
+		return err
+	})
+}
+
+func TestOpenRecallBatches(t *testing.T) {
+	l := newTestLedger(t)
+
+	// Batches listed twice are recalled once
+	var opened *RecallOpenResult
+	l.mustInvoke("OpenRecall", func() (err error) {
+		opened, err = l.tnt.OpenRecall(l.admin(), "led", "flicker", []string{"LED-2", "LED-2"})
+		return err
+	})
+	recall, err := getRecall(l.stub, opened.RecallId)
+	if err != nil {
+		t.Fatalf("getRecall: %s", err)
+	}
+	if len(recall.BatchIds) != 1 || recall.BatchIds[0] != "LED-2" {
+		t.Errorf("unexpected recalled batches %v", recall.BatchIds)
+	}
+
+	// Unregistered batches are known from the assemblies built with them
+	l.mustInvoke("putIndex", func() error {
+		return putIndex(l.stub, keyAssemblyByBatch, "led", "LED-OLD", "ASM-OLD")
+	})
+	l.mustInvoke("OpenRecall", func() error {
+		_, err := l.tnt.OpenRecall(l.admin(), "led", "flicker", []string{"LED-OLD"})
+		return err
+	})
+}
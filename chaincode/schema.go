@@ -0,0 +1,158 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// schemaVersionKey is the world state key of the schema version record
+const schemaVersionKey = "schemaVersion"
+
+// migration upgrades the records written by an older version of the chaincode.
+// Migrations run once, in version order, and must also succeed on a ledger
+// that is already up to date since a fresh deployment runs them all.
+type migration struct {
+	Version     int
+	Description string
+	Apply       func(stub shim.ChaincodeStubInterface) error
+}
+
+// migrations are the registered migrations, in version order. Add new ones at
+// the end with the next version. The records of the legacy table based
+// chaincode are brought over by ImportLegacyRows instead.
+var migrations = []migration{
+	{1, "Seed the default status transitions", seedTransitions},
+	{2, "Seed the default access grants", seedGrants},
+	{3, "Grant the JSON input functions", seedGrantsOf("CreateAssemblyFromJSON", "UpdateAssemblyFromJSON", "CreatePackageFromJSON", "UpdatePackageFromJSON")},
+	{4, "Grant the patch functions", seedGrantsOf("PatchAssembly", "PatchPackage")},
+	{5, "Grant the batch registry functions", seedGrantsOf("RegisterBatch")},
+	{6, "Grant the supplier functions", seedGrantsOf("RegisterSupplier", "SetSupplierStatus", "SubmitBatchAttestation")},
+	{7, "Move the batch columns of the assemblies into their components", migrateComponents},
+	{8, "Grant the bill of materials functions", seedGrantsOf("SetBillOfMaterials")},
+	{9, "Seed the device type catalog", seedDeviceTypes},
+	{10, "Grant the device type catalog functions", seedGrantsOf("SetDeviceType", "RemoveDeviceType")},
+	{11, "Allow delivered packages to be lost", seedTransitionsOf(StatusTransition{objectPackage, PackageDelivered, PackageLost})},
+	{12, "Reserve the batch attestations to the suppliers", revokeGrantsOf(Grant{"SubmitBatchAttestation", RoleAdmin})},
+}
+
+// MigrationRun records a migration applied by InitLedger
+type MigrationRun struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	TxId        string `json:"txId"`
+	AppliedOn   string `json:"appliedOn"`
+}
+
+// SchemaVersion is the schema version of the records in world state and the
+// migrations that brought them there
+type SchemaVersion struct {
+	Version int            `json:"version"`
+	Applied []MigrationRun `json:"applied"`
+}
+
+// readSchemaVersion reads the schema version record. A new ledger is at
+// version 0.
+func readSchemaVersion(stub shim.ChaincodeStubInterface) (*SchemaVersion, error) {
+	value, err := stub.GetState(schemaVersionKey)
+	if err != nil {
+		return nil, errors.New("Failed to read the schema version.")
+	}
+
+	schema := &SchemaVersion{Applied: []MigrationRun{}}
+	if len(value) == 0 {
+		return schema, nil
+	}
+	err = json.Unmarshal(value, schema)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schema version record: %s", err)
+	}
+	return schema, nil
+}
+
+// runMigrations applies the migrations newer than the schema version, records
+// the new version and returns the migrations that ran
+func runMigrations(stub shim.ChaincodeStubInterface) ([]MigrationRun, error) {
+	schema, err := readSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	appliedOn, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	ran := []MigrationRun{}
+	for _, m := range migrations {
+		if m.Version <= schema.Version {
+			continue
+		}
+		err = m.Apply(stub)
+		if err != nil {
+			return nil, fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Description, err)
+		}
+		run := MigrationRun{
+			Version:     m.Version,
+			Description: m.Description,
+			TxId:        stub.GetTxID(),
+			AppliedOn:   appliedOn,
+		}
+		ran = append(ran, run)
+		schema.Applied = append(schema.Applied, run)
+		schema.Version = m.Version
+	}
+
+	value, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(schemaVersionKey, value)
+	if err != nil {
+		return nil, errors.New("Failed to store the schema version.")
+	}
+	return ran, nil
+}
+
+//API to initialise the ledger on deployment and upgrade. It applies the
+//pending migrations and returns the ones that ran. On deployment,
+//operatorMspId is the first operator MSP and the caller must be one of its
+//members; on upgrade, only admins may call it and operatorMspId is empty or
+//an operator MSP.
+func (t *TnT) InitLedger(ctx contractapi.TransactionContextInterface, operatorMspId string) ([]MigrationRun, error) {
+	err := seedOperatorMSP(ctx, operatorMspId)
+	if err != nil {
+		return nil, err
+	}
+	ran, err := runMigrations(ctx.GetStub())
+	if err != nil {
+		return nil, err
+	}
+	return ran, nil
+}
+
+//get the schema version and the migrations applied so far
+func (t *TnT) GetSchemaVersion(ctx contractapi.TransactionContextInterface) (*SchemaVersion, error) {
+	return readSchemaVersion(ctx.GetStub())
+}
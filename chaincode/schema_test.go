@@ -0,0 +1,94 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestInitLedger(t *testing.T) {
+	l := newTestLedger(t)
+
+	schema, err := l.tnt.GetSchemaVersion(l.admin())
+	if err != nil {
+		t.Fatalf("GetSchemaVersion: %s", err)
+	}
+	last := migrations[len(migrations)-1].Version
+	if schema.Version != last || len(schema.Applied) != len(migrations) {
+		t.Errorf("expected version %d after %d migrations, got %+v", last, len(migrations), schema)
+	}
+
+	// Edits of the admins survive the next upgrade
+	l.mustInvoke("RevokeRole", func() error { return l.tnt.RevokeRole(l.admin(), "CreateAssembly", RoleAssembler) })
+
+	var ran []MigrationRun
+	l.mustInvoke("InitLedger", func() (err error) {
+		ran, err = l.tnt.InitLedger(l.admin(), "")
+		return err
+	})
+	if len(ran) != 0 {
+		t.Errorf("up to date ledger ran %d migrations", len(ran))
+	}
+	grants, _ := l.tnt.GetAccessPolicy(l.admin(), "CreateAssembly")
+	if len(grants) != 1 {
+		t.Errorf("revoked grant restored: %v", grants)
+	}
+}
+
+func TestInitLedgerOperatorMSP(t *testing.T) {
+	l := &testLedger{t: t, stub: newTestStub(), tnt: new(TnT)}
+	rogue := l.mspCaller("RogueMSP", hqPlant, RoleAdmin)
+
+	// Anyone may deploy, but only as a member of the first operator MSP
+	l.stub.function = "InitLedger"
+	if err := beforeTransaction(rogue); err != nil {
+		t.Errorf("deployment denied: %s", err)
+	}
+	errs := []struct {
+		name    string
+		fn      func() error
+		wantErr string
+	}{
+		{"no operator MSP", func() error { _, err := l.tnt.InitLedger(rogue, ""); return err }, "The first operator MSP must be given to initialise the ledger."},
+		{"other MSP", func() error { _, err := l.tnt.InitLedger(rogue, "PlantMSP"); return err }, "The first operator MSP PlantMSP must be the MSP of the caller, not RogueMSP."},
+	}
+	for _, test := range errs {
+		t.Run(test.name, func(t *testing.T) {
+			expectError(t, l.invoke(test.fn), test.wantErr)
+		})
+	}
+	l.mustInvoke("InitLedger", func() error {
+		_, err := l.tnt.InitLedger(l.admin(), "PlantMSP")
+		return err
+	})
+
+	// Once seeded, only the admins of the operator MSPs upgrade the ledger
+	l.stub.function = "InitLedger"
+	expectError(t, beforeTransaction(rogue), "Permission denied. InitLedger requires one of the roles [admin], caller has [].")
+	err := l.invoke(func() error {
+		_, err := l.tnt.InitLedger(l.admin(), "RogueMSP")
+		return err
+	})
+	expectError(t, err, "MSP RogueMSP is not an operator MSP. Operator MSPs are added by AddOperatorMSP.")
+	operators, err := l.tnt.GetOperatorMSPs(l.admin())
+	if err != nil || len(operators) != 1 || operators[0].MspId != "PlantMSP" {
+		t.Errorf("unexpected operator MSPs %+v, %v", operators, err)
+	}
+}
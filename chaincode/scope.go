@@ -0,0 +1,105 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// attrPlant is the certificate attribute holding the caller's plant
+const attrPlant = "plant"
+
+// hqPlant is the plant attribute of the headquarters, which reads all plants
+const hqPlant = "HQ"
+
+// plantScope is the set of manufacturing plants a caller may access
+type plantScope struct {
+	plant    string
+	readAll  bool
+	writeAll bool
+}
+
+// callerPlantScope returns the plants the caller may access. Operators are
+// limited to the plant of their certificate. HQ and auditors read every plant
+// and admins also write every plant. The plant is only trusted for the members
+// of the operator MSPs, the others have none.
+func callerPlantScope(ctx contractapi.TransactionContextInterface) plantScope {
+	scope := plantScope{}
+	operator, err := callerIsOperator(ctx)
+	if err != nil || !operator {
+		return scope
+	}
+	if plant, found, err := ctx.GetClientIdentity().GetAttributeValue(attrPlant); err == nil && found {
+		scope.plant = plant
+	}
+
+	roles := callerRoles(ctx)
+	scope.writeAll = stringsContain(roles, RoleAdmin)
+	scope.readAll = scope.writeAll || stringsContain(roles, RoleAuditor) || scope.plant == hqPlant
+	return scope
+}
+
+// canRead reports whether the scope allows reading the assemblies of plant
+func (s plantScope) canRead(plant string) bool {
+	return s.readAll || (s.plant != "" && s.plant == plant)
+}
+
+// canWrite reports whether the scope allows creating or updating the
+// assemblies of plant
+func (s plantScope) canWrite(plant string) bool {
+	return s.writeAll || (s.plant != "" && s.plant == plant)
+}
+
+// checkPlantWrite fails unless the caller may create or update the
+// assemblies of plant
+func checkPlantWrite(ctx contractapi.TransactionContextInterface, plant string) error {
+	scope := callerPlantScope(ctx)
+	if !scope.canWrite(plant) {
+		return fmt.Errorf("Permission denied. Caller of plant %q cannot write assemblies of plant %q.", scope.plant, plant)
+	}
+	return nil
+}
+
+// checkPlantRead fails unless the caller may read the assemblies of plant
+func checkPlantRead(ctx contractapi.TransactionContextInterface, plant string) error {
+	scope := callerPlantScope(ctx)
+	if !scope.canRead(plant) {
+		return fmt.Errorf("Permission denied. Caller of plant %q cannot read assemblies of plant %q.", scope.plant, plant)
+	}
+	return nil
+}
+
+// filterReadable keeps the assemblies the caller may read
+func filterReadable(ctx contractapi.TransactionContextInterface, assemblies []*AssemblyLine) []*AssemblyLine {
+	scope := callerPlantScope(ctx)
+	if scope.readAll {
+		return assemblies
+	}
+
+	readable := []*AssemblyLine{}
+	for _, assembly := range assemblies {
+		if scope.canRead(assembly.ManufacturingPlant) {
+			readable = append(readable, assembly)
+		}
+	}
+	return readable
+}
@@ -0,0 +1,199 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Object types of the composite keys of the records. Each record is stored as
+// JSON under the composite key of its object type and its key attributes.
+const (
+	keyAssembly          = "assembly"
+	keyPackage           = "package"
+	keyHistory           = "history"
+	keyTransition        = "transition"
+	keyMilestone         = "milestone"
+	keyPackageByAssembly = "package~assembly"
+	keyRecall            = "recall"
+	keyRecalledBatch     = "recall~batch"
+	keyGrant             = "grant"
+	keyBatch             = "batch"
+	keySupplier          = "supplier"
+	keyAssemblyByBatch   = "assembly~batch"
+	keyComponentType     = "componentType"
+	keyBOM               = "bom"
+	keyDeviceType        = "deviceType"
+	keyOperatorMSP       = "operatorMsp"
+)
+
+// indexValue is the value of the index entries, whose composite key carries
+// all the information
+var indexValue = []byte{0x00}
+
+// getRecord reads the record stored under objectType and keys into value and
+// reports whether it exists
+func getRecord(stub shim.ChaincodeStubInterface, objectType string, keys []string, value interface{}) (bool, error) {
+	key, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return false, err
+	}
+	data, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read %s %v: %s", objectType, keys, err)
+	}
+	if len(data) == 0 {
+		return false, nil
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return false, fmt.Errorf("Corrupt %s %v: %s", objectType, keys, err)
+	}
+	return true, nil
+}
+
+// putRecord writes a record, creating or overwriting it
+func putRecord(stub shim.ChaincodeStubInterface, objectType string, keys []string, value interface{}) error {
+	key, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, data)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s %v: %s", objectType, keys, err)
+	}
+	return nil
+}
+
+// insertRecord writes a new record. It returns false, without writing, when a
+// record already exists under the same key.
+func insertRecord(stub shim.ChaincodeStubInterface, objectType string, keys []string, value interface{}) (bool, error) {
+	exists, err := recordExists(stub, objectType, keys)
+	if err != nil || exists {
+		return false, err
+	}
+	return true, putRecord(stub, objectType, keys, value)
+}
+
+// replaceRecord overwrites an existing record. It returns false, without
+// writing, when there is no record under the key.
+func replaceRecord(stub shim.ChaincodeStubInterface, objectType string, keys []string, value interface{}) (bool, error) {
+	exists, err := recordExists(stub, objectType, keys)
+	if err != nil || !exists {
+		return false, err
+	}
+	return true, putRecord(stub, objectType, keys, value)
+}
+
+// recordExists reports whether something is stored under objectType and keys
+func recordExists(stub shim.ChaincodeStubInterface, objectType string, keys []string) (bool, error) {
+	key, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return false, err
+	}
+	data, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read %s %v: %s", objectType, keys, err)
+	}
+	return len(data) > 0, nil
+}
+
+// deleteRecord deletes the record or index entry stored under objectType and keys
+func deleteRecord(stub shim.ChaincodeStubInterface, objectType string, keys []string) error {
+	key, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to delete %s %v: %s", objectType, keys, err)
+	}
+	return nil
+}
+
+// putIndex writes an index entry, all of whose data is in its key attributes
+func putIndex(stub shim.ChaincodeStubInterface, index string, attributes ...string) error {
+	key, err := stub.CreateCompositeKey(index, attributes)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, indexValue)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s %v: %s", index, attributes, err)
+	}
+	return nil
+}
+
+// scanRecords calls fn with the key attributes and value of every record of
+// objectType whose key starts with the partial keys, in key order
+func scanRecords(stub shim.ChaincodeStubInterface, objectType string, partialKeys []string, fn func(keys []string, value []byte) error) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(objectType, partialKeys)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve %s %v: %s", objectType, partialKeys, err)
+	}
+	defer iterator.Close()
+
+	return iterate(stub, iterator, fn)
+}
+
+// scanPage is scanRecords limited to a page of pageSize records following
+// bookmark. It returns the bookmark of the next page, empty on the last one.
+func scanPage(stub shim.ChaincodeStubInterface, objectType string, partialKeys []string, pageSize int32, bookmark string, fn func(keys []string, value []byte) error) (string, error) {
+	iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, partialKeys, pageSize, bookmark)
+	if err != nil {
+		return "", fmt.Errorf("Failed to retrieve %s %v: %s", objectType, partialKeys, err)
+	}
+	defer iterator.Close()
+
+	err = iterate(stub, iterator, fn)
+	if err != nil {
+		return "", err
+	}
+	if metadata == nil || metadata.FetchedRecordsCount < pageSize {
+		return "", nil
+	}
+	return metadata.Bookmark, nil
+}
+
+// iterate calls fn for every record of a composite key iterator
+func iterate(stub shim.ChaincodeStubInterface, iterator shim.StateQueryIteratorInterface, fn func(keys []string, value []byte) error) error {
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		_, keys, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return err
+		}
+		err = fn(keys, kv.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Statuses of a supplier. The batches of a suspended supplier cannot be used.
+const (
+	SupplierActive    = "Active"
+	SupplierSuspended = "Suspended"
+)
+
+// Supplier is a component supplier onboarded by the admins. Its identities
+// belong to its own MSP and it signs its batch attestations with the key
+// registered here.
+type Supplier struct {
+	SupplierId     string   `json:"supplierId"`
+	Name           string   `json:"name"`
+	MspId          string   `json:"mspId"`
+	ComponentTypes []string `json:"componentTypes"`
+	// PublicKey is the PEM encoded ECDSA or Ed25519 public key the
+	// attestations are verified with
+	PublicKey    string `json:"publicKey"`
+	Status       string `json:"status"`
+	RegisteredBy string `json:"registeredBy"`
+	RegisteredOn string `json:"registeredOn"`
+	UpdatedBy    string `json:"updatedBy"`
+	UpdatedOn    string `json:"updatedOn"`
+}
+
+// BatchAttestation is the document a supplier signs to declare a batch it
+// shipped
+type BatchAttestation struct {
+	SupplierId    string `json:"supplierId"`
+	ComponentType string `json:"componentType"`
+	BatchId       string `json:"batchId"`
+	Quantity      int    `json:"quantity"`
+	ShippedDate   string `json:"shippedDate"`
+	// CertificateHash is the SHA-256 of the certificate of conformance, in hex
+	CertificateHash string `json:"certificateHash"`
+}
+
+// getSupplier reads a supplier, nil when it does not exist
+func getSupplier(stub shim.ChaincodeStubInterface, supplierId string) (*Supplier, error) {
+	supplier := new(Supplier)
+	found, err := getRecord(stub, keySupplier, []string{supplierId}, supplier)
+	if err != nil || !found {
+		return nil, err
+	}
+	return supplier, nil
+}
+
+// findSupplierByMSP returns the supplier whose identities belong to mspId, nil
+// when there is none
+func findSupplierByMSP(stub shim.ChaincodeStubInterface, mspId string) (*Supplier, error) {
+	var found *Supplier
+	err := scanRecords(stub, keySupplier, []string{}, func(keys []string, value []byte) error {
+		supplier := new(Supplier)
+		err := json.Unmarshal(value, supplier)
+		if err != nil {
+			return fmt.Errorf("Corrupt supplier %s: %s", keys[0], err)
+		}
+		if found == nil && supplier.MspId == mspId {
+			found = supplier
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// checkPlantBatch rejects the batches the plant registers that only the
+// attestations of a supplier may register: those naming a registered supplier
+// and those of a component type an active supplier supplies
+func checkPlantBatch(stub shim.ChaincodeStubInterface, componentType string, supplierName string) error {
+	return scanRecords(stub, keySupplier, []string{}, func(keys []string, value []byte) error {
+		supplier := new(Supplier)
+		err := json.Unmarshal(value, supplier)
+		if err != nil {
+			return fmt.Errorf("Corrupt supplier %s: %s", keys[0], err)
+		}
+		if strings.EqualFold(supplierName, supplier.SupplierId) || strings.EqualFold(supplierName, supplier.Name) {
+			return fmt.Errorf("Supplier %s is registered. Its batches are registered by its attestations.", supplier.SupplierId)
+		}
+		if supplier.Status == SupplierActive && stringsContain(supplier.ComponentTypes, componentType) {
+			return fmt.Errorf("%s batches are supplied by supplier %s. They are registered by its attestations.", componentType, supplier.SupplierId)
+		}
+		return nil
+	})
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key, which must be an ECDSA
+// or Ed25519 key
+func parsePublicKey(publicKey string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, errors.New("Invalid public key. Expecting a PEM encoded public key.")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key: %s", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("Invalid public key. Expecting an ECDSA or Ed25519 key.")
+}
+
+// verifySignature reports whether signature is a signature of message by
+// publicKey: an ASN.1 ECDSA signature of its SHA-256, or an Ed25519 signature
+func verifySignature(publicKey string, message []byte, signature []byte) (bool, error) {
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return false, err
+	}
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature), nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature), nil
+	}
+	return false, nil
+}
+
+// decodeAttestation decodes a batch attestation, or returns an InputError
+// listing every missing or malformed field
+func decodeAttestation(document string) (*BatchAttestation, error) {
+	attestation := new(BatchAttestation)
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(attestation); err != nil {
+		return nil, &InputError{Object: "batch attestation", Fields: []FieldError{{Field: "document", Problem: "is not a valid attestation: " + err.Error()}}}
+	}
+
+	problems := []FieldError{}
+	for _, field := range []struct{ name, value string }{
+		{"batchId", attestation.BatchId},
+		{"componentType", attestation.ComponentType},
+		{"supplierId", attestation.SupplierId},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, FieldError{Field: field.name, Problem: "must not be empty"})
+		}
+	}
+	if _, err := time.Parse("2006-01-02", attestation.ShippedDate); err != nil {
+		problems = append(problems, FieldError{Field: "shippedDate", Problem: "must be a date in YYYY-MM-DD format"})
+	}
+	if len(problems) > 0 {
+		return nil, &InputError{Object: "batch attestation", Fields: problems}
+	}
+	return attestation, nil
+}
+
+//Admin API to onboard a component Supplier. Its identities must belong to
+//mspId and it signs its batch attestations with the private key of publicKey.
+func (t *TnT) RegisterSupplier(ctx contractapi.TransactionContextInterface, supplierId string, name string, mspId string, componentTypes []string, publicKey string) error {
+	stub := ctx.GetStub()
+
+	if supplierId == "" || name == "" || mspId == "" {
+		return errors.New("Supplier id, name and MSP id must not be empty.")
+	}
+	if len(componentTypes) == 0 {
+		return errors.New("A supplier must supply at least one component type.")
+	}
+	types := []string{}
+	for _, componentType := range componentTypes {
+		_componentType, err := getComponentType(stub, componentType)
+		if err != nil {
+			return err
+		}
+		if !stringsContain(types, _componentType) {
+			types = append(types, _componentType)
+		}
+	}
+	if _, err := parsePublicKey(publicKey); err != nil {
+		return err
+	}
+	// The roles in the certificates of an operator MSP are trusted
+	operator, err := recordExists(stub, keyOperatorMSP, []string{mspId})
+	if err != nil {
+		return err
+	}
+	if operator {
+		return fmt.Errorf("MSP %s is an operator MSP.", mspId)
+	}
+
+	_caller, err := callerName(ctx)
+	if err != nil {
+		return err
+	}
+	_time, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	ok, err := insertRecord(stub, keySupplier, []string{supplierId}, &Supplier{
+		SupplierId:     supplierId,
+		Name:           name,
+		MspId:          mspId,
+		ComponentTypes: types,
+		PublicKey:      publicKey,
+		Status:         SupplierActive,
+		RegisteredBy:   _caller,
+		RegisteredOn:   _time,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Supplier %s already exists.", supplierId)
+	}
+	return nil
+}
+
+//Admin API to suspend or reinstate a Supplier. The batches of a suspended
+//supplier cannot be attested nor used in new assemblies.
+func (t *TnT) SetSupplierStatus(ctx contractapi.TransactionContextInterface, supplierId string, status string) error {
+	stub := ctx.GetStub()
+
+	if status != SupplierActive && status != SupplierSuspended {
+		return fmt.Errorf("Invalid supplier status %q. Expecting %s or %s.", status, SupplierActive, SupplierSuspended)
+	}
+	supplier, err := getSupplier(stub, supplierId)
+	if err != nil {
+		return err
+	}
+	if supplier == nil {
+		return fmt.Errorf("Supplier %s not found.", supplierId)
+	}
+	if supplier.Status == status {
+		return nil
+	}
+
+	supplier.Status = status
+	supplier.UpdatedBy, err = callerName(ctx)
+	if err != nil {
+		return err
+	}
+	supplier.UpdatedOn, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	return putRecord(stub, keySupplier, []string{supplierId}, supplier)
+}
+
+//API for a supplier to register a batch it shipped. attestation is a JSON
+//BatchAttestation and signature the base64 signature of its exact bytes by
+//the supplier key. The batch can be used once the signature is verified.
+func (t *TnT) SubmitBatchAttestation(ctx contractapi.TransactionContextInterface, attestation string, signature string) error {
+	stub := ctx.GetStub()
+
+	_attestation, err := decodeAttestation(attestation)
+	if err != nil {
+		return err
+	}
+	_componentType, err := getComponentType(stub, _attestation.ComponentType)
+	if err != nil {
+		return err
+	}
+	err = checkBatchContents(_attestation.Quantity, _attestation.CertificateHash)
+	if err != nil {
+		return err
+	}
+
+	supplier, err := getSupplier(stub, _attestation.SupplierId)
+	if err != nil {
+		return err
+	}
+	if supplier == nil {
+		return fmt.Errorf("Supplier %s not found.", _attestation.SupplierId)
+	}
+	caller, err := callerIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if caller.Org != supplier.MspId {
+		return fmt.Errorf("Permission denied. Caller of MSP %s cannot attest batches of supplier %s.", caller.Org, supplier.SupplierId)
+	}
+	if supplier.Status != SupplierActive {
+		return fmt.Errorf("Supplier %s is suspended.", supplier.SupplierId)
+	}
+	if !stringsContain(supplier.ComponentTypes, _componentType) {
+		return fmt.Errorf("Supplier %s does not supply %s batches.", supplier.SupplierId, _componentType)
+	}
+
+	_signature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("Invalid signature. Expecting base64.")
+	}
+	valid, err := verifySignature(supplier.PublicKey, []byte(attestation), _signature)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fmt.Errorf("Invalid signature of the attestation by supplier %s.", supplier.SupplierId)
+	}
+
+	_time, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	return insertBatch(stub, &ComponentBatch{
+		BatchId:          _attestation.BatchId,
+		ComponentType:    _componentType,
+		Supplier:         supplier.Name,
+		ReceivedDate:     _attestation.ShippedDate,
+		QuantityReceived: _attestation.Quantity,
+		CertificateHash:  _attestation.CertificateHash,
+		RegisteredBy:     caller.String(),
+		RegisteredOn:     _time,
+		SupplierId:       supplier.SupplierId,
+		Attestation:      attestation,
+		Signature:        signature,
+	})
+}
+
+//get a Supplier
+func (t *TnT) GetSupplier(ctx contractapi.TransactionContextInterface, supplierId string) (*Supplier, error) {
+	supplier, err := getSupplier(ctx.GetStub(), supplierId)
+	if err != nil {
+		return nil, err
+	}
+	if supplier == nil {
+		return nil, fmt.Errorf("Supplier %s not found.", supplierId)
+	}
+	return supplier, nil
+}
@@ -0,0 +1,301 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// testSupplierKey is the signing key of a test supplier
+type testSupplierKey struct {
+	t       *testing.T
+	private crypto.Signer
+}
+
+func newECDSASupplierKey(t *testing.T) *testSupplierKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	return &testSupplierKey{t: t, private: key}
+}
+
+func newEd25519SupplierKey(t *testing.T) *testSupplierKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	return &testSupplierKey{t: t, private: key}
+}
+
+// publicKey returns the PEM encoded public key
+func (k *testSupplierKey) publicKey() string {
+	der, err := x509.MarshalPKIXPublicKey(k.private.Public())
+	if err != nil {
+		k.t.Fatalf("MarshalPKIXPublicKey: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// sign returns the base64 signature of document
+func (k *testSupplierKey) sign(document string) string {
+	message, opts := []byte(document), crypto.Hash(0)
+	if _, ok := k.private.(*ecdsa.PrivateKey); ok {
+		digest := sha256.Sum256(message)
+		message, opts = digest[:], crypto.SHA256
+	}
+	signature, err := k.private.Sign(rand.Reader, message, opts)
+	if err != nil {
+		k.t.Fatalf("Sign: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// supplierCaller returns the context of a transaction submitted by an
+// identity of a supplier MSP
+func (l *testLedger) supplierCaller(name string, mspId string) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(&testIdentity{
+		mspId: mspId,
+		attrs: map[string]string{attrEnrollmentId: name, attrRole: RoleSupplier},
+	})
+	return ctx
+}
+
+// registerSupplier onboards a supplier of MSP <supplierId>MSP
+func (l *testLedger) registerSupplier(supplierId string, key *testSupplierKey, componentTypes ...string) {
+	l.t.Helper()
+	l.mustInvoke("RegisterSupplier "+supplierId, func() error {
+		return l.tnt.RegisterSupplier(l.admin(), supplierId, supplierId+" Ltd", supplierId+"MSP", componentTypes, key.publicKey())
+	})
+}
+
+// testAttestation returns an attestation of quantity components of a batch
+func testAttestation(supplierId string, componentType string, batchId string, quantity int) string {
+	return fmt.Sprintf(`{"supplierId": %q, "componentType": %q, "batchId": %q, "quantity": %d, "shippedDate": "2024-03-01", "certificateHash": %q}`,
+		supplierId, componentType, batchId, quantity, testCertificateHash)
+}
+
+func TestRegisterSupplier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	rsaDer, _ := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	rsaPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDer}))
+	key := newECDSASupplierKey(t).publicKey()
+
+	tests := []struct {
+		name           string
+		supplierId     string
+		componentTypes []string
+		publicKey      string
+		wantErr        string
+	}{
+		{"registered", "LUMEN", []string{"LED", "led", "filament"}, key, ""},
+		{"duplicate", "LUMEN", []string{"led"}, key, "Supplier LUMEN already exists."},
+		{"no id", "", []string{"led"}, key, "Supplier id, name and MSP id must not be empty."},
+		{"no component", "BOARDS", nil, key, "A supplier must supply at least one component type."},
+		{"unknown component", "BOARDS", []string{"glass"}, key, `Unknown component type "glass". Expecting one of [filament, led, circuitBoard, wire, casing, adaptor, stickPod].`},
+		{"not PEM", "BOARDS", []string{"circuitBoard"}, "ssh-ed25519 AAAA", "Invalid public key. Expecting a PEM encoded public key."},
+		{"RSA key", "BOARDS", []string{"circuitBoard"}, rsaPEM, "Invalid public key. Expecting an ECDSA or Ed25519 key."},
+	}
+
+	l := newTestLedger(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				return l.tnt.RegisterSupplier(l.admin(), test.supplierId, "Supplier Ltd", "SupplierMSP", test.componentTypes, test.publicKey)
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("RegisterSupplier: %s", err)
+			}
+			supplier, err := l.tnt.GetSupplier(l.admin(), test.supplierId)
+			if err != nil {
+				t.Fatalf("GetSupplier: %s", err)
+			}
+			if fmt.Sprint(supplier.ComponentTypes) != "[led filament]" || supplier.Status != SupplierActive || supplier.RegisteredBy != "admin1@PlantMSP" {
+				t.Errorf("unexpected supplier %+v", supplier)
+			}
+		})
+	}
+
+	_, err = l.tnt.GetSupplier(l.admin(), "NONE")
+	expectError(t, err, "Supplier NONE not found.")
+}
+
+func TestSubmitBatchAttestation(t *testing.T) {
+	lumen := newECDSASupplierKey(t)
+	boards := newEd25519SupplierKey(t)
+	other := newECDSASupplierKey(t)
+
+	signed := func(key *testSupplierKey, attestation string) [2]string {
+		return [2]string{attestation, key.sign(attestation)}
+	}
+	tampered := signed(lumen, testAttestation("LUMEN", "led", "LED-T", 10))
+	tampered[0] = testAttestation("LUMEN", "led", "LED-T", 10000)
+
+	tests := []struct {
+		name     string
+		mspId    string
+		document [2]string
+		wantErr  string
+	}{
+		{"ECDSA", "LUMENMSP", signed(lumen, testAttestation("LUMEN", "LED", "LED-S1", 10)), ""},
+		{"Ed25519", "BOARDSMSP", signed(boards, testAttestation("BOARDS", "circuitBoard", "CB-S1", 10)), ""},
+		{"duplicate", "LUMENMSP", signed(lumen, testAttestation("LUMEN", "led", "LED-1", 10)), "led batch LED-1 already exists."},
+		{"other key", "LUMENMSP", signed(other, testAttestation("LUMEN", "led", "LED-S2", 10)), "Invalid signature of the attestation by supplier LUMEN."},
+		{"tampered", "LUMENMSP", tampered, "Invalid signature of the attestation by supplier LUMEN."},
+		{"not base64", "LUMENMSP", [2]string{testAttestation("LUMEN", "led", "LED-S2", 10), "%%"}, "Invalid signature. Expecting base64."},
+		{"other MSP", "BOARDSMSP", signed(lumen, testAttestation("LUMEN", "led", "LED-S2", 10)), "Permission denied. Caller of MSP BOARDSMSP cannot attest batches of supplier LUMEN."},
+		{"not supplied", "LUMENMSP", signed(lumen, testAttestation("LUMEN", "wire", "WIRE-S1", 10)), "Supplier LUMEN does not supply wire batches."},
+		{"unknown supplier", "LUMENMSP", signed(lumen, testAttestation("NONE", "led", "LED-S2", 10)), "Supplier NONE not found."},
+		{"no quantity", "LUMENMSP", signed(lumen, testAttestation("LUMEN", "led", "LED-S2", 0)), "Invalid quantity 0. Expecting at least 1."},
+		{"missing fields", "LUMENMSP", signed(lumen, `{"supplierId": "LUMEN", "quantity": 10, "shippedDate": "March"}`), "Invalid batch attestation: batchId must not be empty; componentType must not be empty; shippedDate must be a date in YYYY-MM-DD format."},
+		{"unknown field", "LUMENMSP", signed(lumen, `{"supplierId": "LUMEN", "color": "red"}`), `Invalid batch attestation: document is not a valid attestation: json: unknown field "color".`},
+	}
+
+	l := newTestLedger(t)
+	l.registerSupplier("LUMEN", lumen, "led")
+	l.registerSupplier("BOARDS", boards, "circuitBoard")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.invoke(func() error {
+				return l.tnt.SubmitBatchAttestation(l.supplierCaller("shipper1", test.mspId), test.document[0], test.document[1])
+			})
+			if test.wantErr != "" {
+				expectError(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("SubmitBatchAttestation: %s", err)
+			}
+		})
+	}
+
+	batch, err := l.tnt.GetBatch(l.admin(), "led", "LED-S1")
+	if err != nil {
+		t.Fatalf("GetBatch: %s", err)
+	}
+	if batch.SupplierId != "LUMEN" || batch.Supplier != "LUMEN Ltd" || batch.QuantityReceived != 10 || batch.ReceivedDate != "2024-03-01" || batch.RegisteredBy != "shipper1@LUMENMSP" {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+	// The stored attestation can be verified again by anyone
+	signature, _ := base64.StdEncoding.DecodeString(batch.Signature)
+	if ok, err := verifySignature(lumen.publicKey(), []byte(batch.Attestation), signature); !ok || err != nil {
+		t.Errorf("stored attestation does not verify: %v", err)
+	}
+}
+
+func TestSuspendedSupplierBatches(t *testing.T) {
+	l := newTestLedger(t)
+	key := newECDSASupplierKey(t)
+	l.registerSupplier("LUMEN", key, "led")
+	supplier := l.supplierCaller("shipper1", "LUMENMSP")
+	attest := func(batchId string) error {
+		attestation := testAttestation("LUMEN", "led", batchId, 10)
+		return l.invoke(func() error {
+			return l.tnt.SubmitBatchAttestation(supplier, attestation, key.sign(attestation))
+		})
+	}
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	create := func(serialNo string) error {
+		return l.invoke(func() error {
+			_, err := l.tnt.CreateAssemblyFromJSON(ctx, `{"deviceSerialNo": "`+serialNo+`", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created", "ledBatchId": "LED-S1"}`)
+			return err
+		})
+	}
+	setStatus := func(status string) {
+		l.mustInvoke("SetSupplierStatus "+status, func() error {
+			return l.tnt.SetSupplierStatus(l.admin(), "LUMEN", status)
+		})
+	}
+
+	if err := attest("LED-S1"); err != nil {
+		t.Fatalf("attest: %s", err)
+	}
+	if err := create("SN-1"); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+
+	setStatus(SupplierSuspended)
+	expectError(t, create("SN-2"), "led batch LED-S1 is from suspended supplier LUMEN.")
+	expectError(t, attest("LED-S2"), "Supplier LUMEN is suspended.")
+
+	setStatus(SupplierActive)
+	if err := create("SN-2"); err != nil {
+		t.Errorf("create after reinstating: %s", err)
+	}
+
+	err := l.invoke(func() error { return l.tnt.SetSupplierStatus(l.admin(), "LUMEN", "Banned") })
+	expectError(t, err, `Invalid supplier status "Banned". Expecting Active or Suspended.`)
+	err = l.invoke(func() error { return l.tnt.SetSupplierStatus(l.admin(), "NONE", SupplierSuspended) })
+	expectError(t, err, "Supplier NONE not found.")
+
+	l.stub.function = "SubmitBatchAttestation"
+	if err := beforeTransaction(supplier); err != nil {
+		t.Errorf("supplier denied: %s", err)
+	}
+	expectError(t, beforeTransaction(ctx), "Permission denied. SubmitBatchAttestation requires one of the roles [supplier], caller has [assembler].")
+	expectError(t, beforeTransaction(l.admin()), "Permission denied. SubmitBatchAttestation requires one of the roles [supplier], caller has [admin].")
+}
+
+func TestPlantBatchesOfSuppliers(t *testing.T) {
+	l := newTestLedger(t)
+	l.registerSupplier("LUMEN", newECDSASupplierKey(t), "led")
+	clerk := l.caller("clerk1", "PLANT1", RoleLogistics)
+	register := func(componentType string, batchId string, supplier string) error {
+		return l.invoke(func() error {
+			return l.tnt.RegisterBatch(clerk, componentType, batchId, supplier, "2024-01-01", 10, testCertificateHash)
+		})
+	}
+
+	// The batches of an onboarded supplier are only registered by its attestations
+	expectError(t, register("led", "LED-P1", "ACME"), "led batches are supplied by supplier LUMEN. They are registered by its attestations.")
+	expectError(t, register("wire", "WIRE-P1", "lumen ltd"), "Supplier LUMEN is registered. Its batches are registered by its attestations.")
+	if err := register("wire", "WIRE-P1", "ACME"); err != nil {
+		t.Errorf("batch of another supplier: %s", err)
+	}
+
+	// Once the supplier is suspended, the plant may source its components elsewhere
+	l.mustInvoke("SetSupplierStatus", func() error { return l.tnt.SetSupplierStatus(l.admin(), "LUMEN", SupplierSuspended) })
+	if err := register("led", "LED-P1", "ACME"); err != nil {
+		t.Errorf("batch of a component of a suspended supplier: %s", err)
+	}
+	expectError(t, register("led", "LED-P2", "LUMEN"), "Supplier LUMEN is registered. Its batches are registered by its attestations.")
+}
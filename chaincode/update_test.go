@@ -0,0 +1,223 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestUpdateAssemblyByIDReplacesAssembly(t *testing.T) {
+	l := newTestLedger(t)
+	creator := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(creator, "SN-1", DeviceTypeHolder, "PLANT1")
+	created := l.assembly(assemblyId)
+
+	updater := l.caller("op2", "PLANT1", RoleAssembler)
+	err := l.invoke(func() error {
+		return l.tnt.UpdateAssemblyByID(updater, assemblyId, "SN-1", DeviceTypeHolder, "FIL-2", "LED-1", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", "PLANT1", AssemblyInAssembly, "1999-01-01")
+	})
+	if err != nil {
+		t.Fatalf("UpdateAssemblyByID: %s", err)
+	}
+
+	updated := l.assembly(assemblyId)
+	if updated.FilamentBatchId != "FIL-2" || updated.AssemblyStatus != AssemblyInAssembly {
+		t.Errorf("update not applied: %+v", updated)
+	}
+	if updated.AssemblyCreatedBy != created.AssemblyCreatedBy || updated.AssemblyCreationDate != created.AssemblyCreationDate {
+		t.Errorf("creator changed from %s on %s to %s on %s", created.AssemblyCreatedBy, created.AssemblyCreationDate, updated.AssemblyCreatedBy, updated.AssemblyCreationDate)
+	}
+	if updated.AssemblyLastUpdatedBy != "op2@PlantMSP" {
+		t.Errorf("expected last updater op2@PlantMSP, got %s", updated.AssemblyLastUpdatedBy)
+	}
+
+	// The row is replaced, not duplicated
+	all, err := l.tnt.GetAllAssembly(updater)
+	if err != nil {
+		t.Fatalf("GetAllAssembly: %s", err)
+	}
+	if len(all) != 1 {
+		t.Errorf("expected 1 assembly, got %d", len(all))
+	}
+
+	// The status index follows the update
+	created2, _ := l.tnt.GetAllAssemblyByStatus(updater, AssemblyCreated)
+	inAssembly, _ := l.tnt.GetAllAssemblyByStatus(updater, AssemblyInAssembly)
+	if len(created2) != 0 || len(inAssembly) != 1 {
+		t.Errorf("expected the assembly indexed under %s only, got %d Created and %d InAssembly", AssemblyInAssembly, len(created2), len(inAssembly))
+	}
+
+	history, err := l.tnt.GetAssemblyHistory(updater, assemblyId)
+	if err != nil {
+		t.Fatalf("GetAssemblyHistory: %s", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 versions, got %d", len(history))
+	}
+}
+
+func TestUpdateAssemblyByIDUnknownAssembly(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+
+	err := l.invoke(func() error {
+		return l.tnt.UpdateAssemblyByID(ctx, "ASM-NONE", "SN-1", DeviceTypeHolder, "FIL-1", "LED-1", "CB-1", "WIRE-1", "CASE-1", "ADP-1", "SP-1", "PLANT1", AssemblyCreated, "2024-01-01")
+	})
+	expectError(t, err, "Assembly ASM-NONE not found.")
+
+	err = l.invoke(func() error {
+		return l.tnt.UpdateAssemblyFromJSON(ctx, `{"assemblyId": "ASM-NONE", "deviceSerialNo": "SN-1", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "Created"}`)
+	})
+	expectError(t, err, "Assembly ASM-NONE not found.")
+
+	// Nothing was created
+	if l.assembly("ASM-NONE") != nil {
+		t.Errorf("update created assembly ASM-NONE")
+	}
+	history, err := getHistory(l.stub, objectAssembly, "ASM-NONE")
+	if err != nil {
+		t.Fatalf("getHistory: %s", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected no history, got %d versions", len(history))
+	}
+}
+
+func TestUpdateAssemblyFromJSONIgnoresReadOnlyFields(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("op1", "PLANT1", RoleAssembler)
+	assemblyId := l.createAssembly(ctx, "SN-1", DeviceTypeHolder, "PLANT1")
+	created := l.assembly(assemblyId)
+
+	l.mustInvoke("UpdateAssemblyFromJSON", func() error {
+		return l.tnt.UpdateAssemblyFromJSON(ctx, `{"assemblyId": "`+assemblyId+`", "deviceSerialNo": "SN-1b", "deviceType": "Holder", "manufacturingPlant": "PLANT1", "assemblyStatus": "InAssembly", "assemblyCreatedBy": "mallory", "assemblyCreationDate": "1999-01-01", "caseId": "CASE-X"}`)
+	})
+
+	updated := l.assembly(assemblyId)
+	if updated.DeviceSerialNo != "SN-1b" || updated.AssemblyStatus != AssemblyInAssembly || updated.FilamentBatchId != "" {
+		t.Errorf("update not applied: %+v", updated)
+	}
+	if updated.AssemblyCreatedBy != created.AssemblyCreatedBy || updated.AssemblyCreationDate != created.AssemblyCreationDate || updated.CaseId != "" {
+		t.Errorf("read-only fields changed: %+v", updated)
+	}
+}
+
+func TestUpdatePackageByCaseIDReplacesPackage(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RolePacker+","+RoleAssembler)
+	caseId := l.packedCase(packer, "PLANT1")
+	created := l.packageLine(caseId)
+
+	err := l.invoke(func() error {
+		return l.tnt.UpdatePackageByCaseID(packer, caseId, created.HolderAssemblyId, created.ChargerAssemblyId, PackageReadyToShip, "2024-01-03", "2 Side St", "1999-01-01")
+	})
+	if err != nil {
+		t.Fatalf("UpdatePackageByCaseID: %s", err)
+	}
+
+	updated := l.packageLine(caseId)
+	if updated.PackageStatus != PackageReadyToShip || updated.ShippingToAddress != "2 Side St" || updated.PackagingDate != "2024-01-03" {
+		t.Errorf("update not applied: %+v", updated)
+	}
+	if updated.PackageCreatedBy != created.PackageCreatedBy || updated.PackageCreationDate != created.PackageCreationDate {
+		t.Errorf("creator changed from %s on %s to %s on %s", created.PackageCreatedBy, created.PackageCreationDate, updated.PackageCreatedBy, updated.PackageCreationDate)
+	}
+
+	all, err := l.tnt.GetAllPackage(packer)
+	if err != nil {
+		t.Fatalf("GetAllPackage: %s", err)
+	}
+	if len(all) != 1 {
+		t.Errorf("expected 1 package, got %d", len(all))
+	}
+
+	// Both assemblies stay indexed to the case
+	for _, assemblyId := range []string{created.HolderAssemblyId, created.ChargerAssemblyId} {
+		_package, err := l.tnt.GetPackageByAssemblyID(packer, assemblyId)
+		if err != nil {
+			t.Fatalf("GetPackageByAssemblyID %s: %s", assemblyId, err)
+		}
+		if _package.CaseId != caseId {
+			t.Errorf("assembly %s indexed to case %s, expected %s", assemblyId, _package.CaseId, caseId)
+		}
+	}
+
+	history, err := l.tnt.GetPackageHistory(packer, caseId)
+	if err != nil {
+		t.Fatalf("GetPackageHistory: %s", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 versions, got %d", len(history))
+	}
+}
+
+func TestUpdatePackageKeepsAssemblies(t *testing.T) {
+	l := newTestLedger(t)
+	packer := l.caller("packer1", "PLANT1", RolePacker+","+RoleAssembler)
+	caseId := l.packedCase(packer, "PLANT1")
+	created := l.packageLine(caseId)
+	otherHolderId := l.packableAssembly(packer, "SN-H-2", DeviceTypeHolder, "PLANT1")
+	otherChargerId := l.packableAssembly(packer, "SN-C-2", DeviceTypeCharger, "PLANT1")
+
+	err := l.invoke(func() error {
+		return l.tnt.UpdatePackageByCaseID(packer, caseId, otherHolderId, created.ChargerAssemblyId, PackagePacked, "2024-01-02", "1 Main St", "")
+	})
+	expectError(t, err, "Invalid package: holderAssemblyId cannot be changed.")
+
+	err = l.invoke(func() error {
+		return l.tnt.UpdatePackageFromJSON(packer, `{"caseId": "`+caseId+`", "holderAssemblyId": "`+otherHolderId+`", "chargerAssemblyId": "`+otherChargerId+`", "packageStatus": "Packed"}`)
+	})
+	expectError(t, err, "Invalid package: holderAssemblyId cannot be changed; chargerAssemblyId cannot be changed.")
+
+	for _, assemblyId := range []string{otherHolderId, otherChargerId} {
+		if assembly := l.assembly(assemblyId); assembly.CaseId != "" || assembly.AssemblyStatus != AssemblyQAPassed {
+			t.Errorf("assembly %s packed by a rejected update: %+v", assemblyId, assembly)
+		}
+	}
+	if _package := l.packageLine(caseId); _package.HolderAssemblyId != created.HolderAssemblyId || _package.ChargerAssemblyId != created.ChargerAssemblyId {
+		t.Errorf("assemblies of case %s changed: %+v", caseId, _package)
+	}
+}
+
+func TestUpdatePackageByCaseIDUnknownPackage(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := l.caller("packer1", "PLANT1", RolePacker)
+
+	err := l.invoke(func() error {
+		return l.tnt.UpdatePackageByCaseID(ctx, "CASE-NONE", "ASM-1", "ASM-2", PackagePacked, "2024-01-02", "1 Main St", "2024-01-01")
+	})
+	expectError(t, err, "Package CASE-NONE not found.")
+
+	err = l.invoke(func() error {
+		return l.tnt.UpdatePackageFromJSON(ctx, `{"caseId": "CASE-NONE", "holderAssemblyId": "ASM-1", "chargerAssemblyId": "ASM-2", "packageStatus": "Packed"}`)
+	})
+	expectError(t, err, "Package CASE-NONE not found.")
+
+	if l.packageLine("CASE-NONE") != nil {
+		t.Errorf("update created package CASE-NONE")
+	}
+	caseId, err := getCaseIdByAssembly(l.stub, "ASM-1")
+	if err != nil {
+		t.Fatalf("getCaseIdByAssembly: %s", err)
+	}
+	if caseId != "" {
+		t.Errorf("update indexed assembly ASM-1 to case %s", caseId)
+	}
+}